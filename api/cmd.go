@@ -1,19 +1,27 @@
 package api
 
 import (
-	"os"
 	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/cache"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
 var cmdLog = logging.MustGetLogger("cmd")
 
+var cacheNamespaces = map[string][]string{
+	"tmdb":  []string{"com.tmdb."},
+	"tvdb":  []string{"com.tvdb."},
+	"fanart": []string{"com.fanart."},
+	"trakt": []string{"com.trakt.", cache.TraktPageCachePrefix},
+	"pages": []string{cache.PageCachePrefix},
+}
+
 func ClearCache(ctx *gin.Context) {
-	os.RemoveAll(filepath.Join(config.Get().Info.Profile, "cache"))
+	cache.SharedStore(filepath.Join(config.Get().Info.Profile, "cache")).Flush()
 	xbmc.Notify("Quasar", "LOCALIZE[30200]", config.AddonIcon())
 }
 
@@ -27,3 +35,30 @@ func SetViewMode(ctx *gin.Context) {
 	}
 	ctx.String(200, "")
 }
+
+func CacheStats(store *cache.LRUStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, store.Stats())
+	}
+}
+
+func PurgeCache(store *cache.LRUStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		namespace := ctx.Params.ByName("namespace")
+		prefixes, ok := cacheNamespaces[namespace]
+		if !ok {
+			ctx.String(404, "Unknown cache namespace %s", namespace)
+			return
+		}
+		purged := 0
+		for _, prefix := range prefixes {
+			removed, err := store.Purge(prefix)
+			if err != nil {
+				cmdLog.Errorf("Unable to purge %s from cache: %s", prefix, err)
+			}
+			purged += removed
+		}
+		cmdLog.Infof("Purged %d %s items from cache", purged, namespace)
+		ctx.JSON(200, gin.H{"namespace": namespace, "purged": purged})
+	}
+}
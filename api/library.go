@@ -87,7 +87,11 @@ func Lookup(ctx *gin.Context) {
 	Shows := make([]*Item, 0, len(db.Shows))
 
 	for i := 0; i < len(db.Movies); i++ {
-		movie := tmdb.GetMovieById(db.Movies[i], "en")
+		movie, err := tmdb.GetMovieById(db.Movies[i], config.Get().Language)
+		if err != nil {
+			libraryLog.Errorf("Unable to get movie %s: %s", db.Movies[i], err)
+			continue
+		}
 		Movies = append(Movies, &Item{
 			Id: db.Movies[i],
 			Title: movie.OriginalTitle,
@@ -99,8 +103,8 @@ func Lookup(ctx *gin.Context) {
 
 	for i := 0; i < len(db.Shows); i++ {
 		showId, _ := strconv.Atoi(db.Shows[i])
-		show := tmdb.GetShow(showId, "en")
-		if show == nil {
+		show, err := tmdb.GetShow(showId, config.Get().Language)
+		if err != nil {
 			ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 			ctx.JSON(200, gin.H{
 				"success": false,
@@ -357,7 +361,10 @@ func AddMovie(ctx *gin.Context) {
 }
 
 func WriteMovieStrm(tmdbId string, MoviesLibraryPath string) error {
-	movie := tmdb.GetMovieById(tmdbId, "en")
+	movie, err := tmdb.GetMovieById(tmdbId, config.Get().Language)
+	if err != nil {
+		return err
+	}
 	MovieStrm := toFileName(fmt.Sprintf("%s (%s)", movie.OriginalTitle, strings.Split(movie.ReleaseDate, "-")[0]))
 	MoviePath := filepath.Join(MoviesLibraryPath, MovieStrm)
 
@@ -382,7 +389,11 @@ func RemoveMovie(ctx *gin.Context) {
 	MoviesLibraryPath := filepath.Join(LibraryPath, "Movies")
 	DBPath := filepath.Join(LibraryPath, fmt.Sprintf("%s.json", DBName))
 	tmdbId := ctx.Params.ByName("tmdbId")
-	movie := tmdb.GetMovieById(tmdbId, "en")
+	movie, err := tmdb.GetMovieById(tmdbId, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 	MovieStrm := toFileName(fmt.Sprintf("%s (%s)", movie.OriginalTitle, strings.Split(movie.ReleaseDate, "-")[0]))
 	MoviePath := filepath.Join(MoviesLibraryPath, MovieStrm)
 
@@ -461,13 +472,40 @@ func AddShow(ctx *gin.Context) {
 	libraryLog.Info("Show added")
 }
 
+// showStrmName returns the folder name of a show in the user's language, or
+// the one of an existing folder added in a fallback language, so changing
+// languages doesn't duplicate shows in the library.
+func showStrmName(Id int, ShowsLibraryPath string) (string, error) {
+	ShowStrm := ""
+	for _, language := range tmdb.LanguageFallbacks(config.Get().Language) {
+		show, err := tmdb.GetShow(Id, language)
+		if err != nil {
+			continue
+		}
+		name := toFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
+		if ShowStrm == "" {
+			ShowStrm = name
+		}
+		if _, err := os.Stat(filepath.Join(ShowsLibraryPath, name)); err == nil {
+			return name, nil
+		}
+	}
+	if ShowStrm == "" {
+		return "", errors.New("Unable to get Show")
+	}
+	return ShowStrm, nil
+}
+
 func WriteShowStrm(showId string, ShowsLibraryPath string) error {
 	Id, _ := strconv.Atoi(showId)
-	show := tmdb.GetShow(Id, "en")
-	if show == nil {
-		return errors.New("Unable to get Show")
+	show, err := tmdb.GetShow(Id, config.Get().Language)
+	if err != nil {
+		return err
+	}
+	ShowStrm, err := showStrmName(Id, ShowsLibraryPath)
+	if err != nil {
+		return err
 	}
-	ShowStrm := toFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
 	ShowPath := filepath.Join(ShowsLibraryPath, ShowStrm)
 
 	if _, err := os.Stat(ShowPath); os.IsNotExist(err) {
@@ -487,7 +525,11 @@ func WriteShowStrm(showId string, ShowsLibraryPath string) error {
 			continue
 		}
 
-		episodes := tmdb.GetSeason(Id, season.Season, "en").Episodes
+		tmdbSeason, err := tmdb.GetSeason(Id, season.Season, config.Get().Language)
+		if err != nil {
+			return err
+		}
+		episodes := tmdbSeason.Episodes
 
 		for _, episode := range episodes {
 			if episode.AirDate == "" {
@@ -516,12 +558,11 @@ func RemoveShow(ctx *gin.Context) {
 	DBPath := filepath.Join(LibraryPath, fmt.Sprintf("%s.json", DBName))
 	showId := ctx.Params.ByName("showId")
 	Id, _ := strconv.Atoi(showId)
-	show := tmdb.GetShow(Id, "en")
-	if show == nil {
+	ShowStrm, err := showStrmName(Id, ShowsLibraryPath)
+	if err != nil {
 		ctx.String(404, "")
 		return
 	}
-	ShowStrm := toFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
 	ShowPath := filepath.Join(ShowsLibraryPath, ShowStrm)
 
 	if err := RemoveFromJsonDB(DBPath, showId, LShow); err != nil {
@@ -0,0 +1,426 @@
+package api
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"strconv"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+const (
+	librarySyncCheck = 5 * time.Minute
+)
+
+var (
+	errInvalidLibraryPath = errors.New("Invalid library path")
+
+	librarySyncLock = sync.Mutex{}
+	lastLibrarySync time.Time
+)
+
+// SyncReport lists what a library sync changed, or would change when it's a
+// dry run.
+type SyncReport struct {
+	DryRun       bool     `json:"dry_run"`
+	AddedMovies  []string `json:"added_movies"`
+	AddedShows   []string `json:"added_shows"`
+	PushedMovies []string `json:"pushed_movies"`
+	PushedShows  []string `json:"pushed_shows"`
+	// Library items left out of the push because they're on Trakt already,
+	// e.g. from the watchlist, which isn't the collection
+	NotPushed    []string `json:"not_pushed"`
+	Errors       []string `json:"errors"`
+}
+
+func (r *SyncReport) fail(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	libraryLog.Error(message)
+	r.Errors = append(r.Errors, message)
+}
+
+func readJsonDB(DBPath string) (DataBase, error) {
+	var db DataBase
+	if _, err := os.Stat(DBPath); err == nil {
+		file, err := ioutil.ReadFile(DBPath)
+		if err != nil {
+			return db, err
+		}
+		if err := json.Unmarshal(file, &db); err != nil {
+			return db, fmt.Errorf("Invalid json DB %s: %s", DBPath, err)
+		}
+	}
+	return db, nil
+}
+
+func movieLabel(movie *trakt.Movie) string {
+	return fmt.Sprintf("%s (%d)", movie.Title, movie.Year)
+}
+
+func showLabel(show *trakt.Show) string {
+	return fmt.Sprintf("%s (%d)", show.Title, show.Year)
+}
+
+func dateLabel(title string, date string) string {
+	if len(date) >= 4 {
+		return fmt.Sprintf("%s (%s)", title, date[:4])
+	}
+	return title
+}
+
+// tmdbMovieLabels names library movies by their TMDB ids.
+func tmdbMovieLabels(tmdbIds []int) []string {
+	labels := make([]string, 0, len(tmdbIds))
+	for i, movie := range tmdb.GetMovies(tmdbIds, config.Get().Language) {
+		if movie == nil {
+			labels = append(labels, fmt.Sprintf("TMDB %d", tmdbIds[i]))
+			continue
+		}
+		labels = append(labels, dateLabel(movie.Title, movie.ReleaseDate))
+	}
+	return labels
+}
+
+func tmdbShowLabels(tmdbIds []int) []string {
+	labels := make([]string, 0, len(tmdbIds))
+	for i, show := range tmdb.GetShows(tmdbIds, config.Get().Language) {
+		if show == nil {
+			labels = append(labels, fmt.Sprintf("TMDB %d", tmdbIds[i]))
+			continue
+		}
+		labels = append(labels, dateLabel(show.Name, show.FirstAirDate))
+	}
+	return labels
+}
+
+// traktSyncMovies returns the movies of the Trakt sources enabled in the
+// settings, by TMDB id.
+func traktSyncMovies(report *SyncReport) map[int]*trakt.Movie {
+	sources := make([][]*trakt.Movies, 0)
+	if config.Get().TraktSyncWatchlist {
+		if movies, err := trakt.WatchlistMovies(); err != nil {
+			report.fail("Unable to get Trakt movie watchlist: %s", err)
+		} else {
+			sources = append(sources, movies)
+		}
+	}
+	if config.Get().TraktSyncCollection {
+		if movies, err := trakt.CollectionMovies(); err != nil {
+			report.fail("Unable to get Trakt movie collection: %s", err)
+		} else {
+			sources = append(sources, movies)
+		}
+	}
+	for _, listId := range config.Get().TraktSyncLists {
+		if movies, err := trakt.ListMovies(listId); err != nil {
+			report.fail("Unable to get movies of Trakt list %s: %s", listId, err)
+		} else {
+			sources = append(sources, movies)
+		}
+	}
+
+	movies := make(map[int]*trakt.Movie)
+	for _, source := range sources {
+		for _, listing := range source {
+			if listing.Movie == nil || listing.Movie.IDs == nil || listing.Movie.IDs.TMDB == 0 {
+				continue
+			}
+			movies[listing.Movie.IDs.TMDB] = listing.Movie
+		}
+	}
+	return movies
+}
+
+func traktSyncShows(report *SyncReport) map[int]*trakt.Show {
+	sources := make([][]*trakt.Shows, 0)
+	if config.Get().TraktSyncWatchlist {
+		if shows, err := trakt.WatchlistShows(); err != nil {
+			report.fail("Unable to get Trakt show watchlist: %s", err)
+		} else {
+			sources = append(sources, shows)
+		}
+	}
+	if config.Get().TraktSyncCollection {
+		if shows, err := trakt.CollectionShows(); err != nil {
+			report.fail("Unable to get Trakt show collection: %s", err)
+		} else {
+			sources = append(sources, shows)
+		}
+	}
+	for _, listId := range config.Get().TraktSyncLists {
+		if shows, err := trakt.ListShows(listId); err != nil {
+			report.fail("Unable to get shows of Trakt list %s: %s", listId, err)
+		} else {
+			sources = append(sources, shows)
+		}
+	}
+
+	shows := make(map[int]*trakt.Show)
+	for _, source := range sources {
+		for _, listing := range source {
+			if listing.Show == nil || listing.Show.IDs == nil || listing.Show.IDs.TMDB == 0 {
+				continue
+			}
+			shows[listing.Show.IDs.TMDB] = listing.Show
+		}
+	}
+	return shows
+}
+
+// pushLibrary adds the library items missing from the Trakt collection to
+// it. Items of the synced Trakt sources are left out, pushing them would
+// turn the watchlist and lists into the collection.
+func pushLibrary(db DataBase, fromTrakt map[string]bool, report *SyncReport) {
+	collected := make(map[string]bool)
+	movies, err := trakt.CollectionMovies()
+	if err != nil {
+		report.fail("Unable to get Trakt movie collection: %s", err)
+		return
+	}
+	for _, listing := range movies {
+		if listing.Movie != nil && listing.Movie.IDs != nil {
+			collected["movie." + strconv.Itoa(listing.Movie.IDs.TMDB)] = true
+		}
+	}
+	shows, err := trakt.CollectionShows()
+	if err != nil {
+		report.fail("Unable to get Trakt show collection: %s", err)
+		return
+	}
+	for _, listing := range shows {
+		if listing.Show != nil && listing.Show.IDs != nil {
+			collected["show." + strconv.Itoa(listing.Show.IDs.TMDB)] = true
+		}
+	}
+
+	movieIds := make([]int, 0)
+	notPushedMovies := make([]int, 0)
+	for _, movieId := range db.Movies {
+		tmdbId, err := strconv.Atoi(movieId)
+		if err != nil || collected["movie." + movieId] {
+			continue
+		}
+		if fromTrakt["movie." + movieId] {
+			notPushedMovies = append(notPushedMovies, tmdbId)
+			continue
+		}
+		movieIds = append(movieIds, tmdbId)
+	}
+	showIds := make([]int, 0)
+	notPushedShows := make([]int, 0)
+	for _, showId := range db.Shows {
+		tmdbId, err := strconv.Atoi(showId)
+		if err != nil || collected["show." + showId] {
+			continue
+		}
+		if fromTrakt["show." + showId] {
+			notPushedShows = append(notPushedShows, tmdbId)
+			continue
+		}
+		showIds = append(showIds, tmdbId)
+	}
+	report.NotPushed = append(tmdbMovieLabels(notPushedMovies), tmdbShowLabels(notPushedShows)...)
+
+	if report.DryRun == false && len(movieIds) > 0 {
+		if err := trakt.AddToCollection("movies", movieIds...); err != nil {
+			report.fail("Unable to add movies to the Trakt collection: %s", err)
+			movieIds = nil
+		}
+	}
+	if report.DryRun == false && len(showIds) > 0 {
+		if err := trakt.AddToCollection("shows", showIds...); err != nil {
+			report.fail("Unable to add shows to the Trakt collection: %s", err)
+			showIds = nil
+		}
+	}
+	report.PushedMovies = tmdbMovieLabels(movieIds)
+	report.PushedShows = tmdbShowLabels(showIds)
+}
+
+// SyncLibrary adds the movies and shows of the Trakt sources enabled in the
+// settings to the library, and pushes the library back to the Trakt
+// collection if enabled. A dry run only reports what would change.
+func SyncLibrary(dryRun bool) (*SyncReport, error) {
+	librarySyncLock.Lock()
+	defer librarySyncLock.Unlock()
+	if dryRun == false {
+		lastLibrarySync = time.Now()
+	}
+
+	report := &SyncReport{
+		DryRun:       dryRun,
+		AddedMovies:  make([]string, 0),
+		AddedShows:   make([]string, 0),
+		PushedMovies: make([]string, 0),
+		PushedShows:  make([]string, 0),
+		NotPushed:    make([]string, 0),
+		Errors:       make([]string, 0),
+	}
+
+	LibraryPath := config.Get().LibraryPath
+	if fileInfo, err := os.Stat(LibraryPath); err != nil || fileInfo.IsDir() == false || LibraryPath == "" || LibraryPath == "." {
+		return nil, errInvalidLibraryPath
+	}
+	if trakt.Authorized() == false {
+		return nil, trakt.ErrNotAuthorized
+	}
+
+	DBPath := filepath.Join(LibraryPath, fmt.Sprintf("%s.json", DBName))
+	db, err := readJsonDB(DBPath)
+	if err != nil {
+		return nil, err
+	}
+	inLibrary := make(map[string]bool)
+	for _, movieId := range db.Movies {
+		inLibrary["movie." + movieId] = true
+	}
+	for _, showId := range db.Shows {
+		inLibrary["show." + showId] = true
+	}
+
+	MoviesLibraryPath := filepath.Join(LibraryPath, "Movies")
+	ShowsLibraryPath := filepath.Join(LibraryPath, "Shows")
+	if dryRun == false {
+		for _, libraryPath := range []string{MoviesLibraryPath, ShowsLibraryPath} {
+			if _, err := os.Stat(libraryPath); os.IsNotExist(err) {
+				if err := os.Mkdir(libraryPath, 0755); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	fromTrakt := make(map[string]bool)
+	for tmdbId, movie := range traktSyncMovies(report) {
+		movieId := strconv.Itoa(tmdbId)
+		fromTrakt["movie." + movieId] = true
+		if inLibrary["movie." + movieId] {
+			continue
+		}
+		if dryRun == false {
+			if err := WriteMovieStrm(movieId, MoviesLibraryPath); err != nil {
+				report.fail("Unable to add movie %s: %s", movieLabel(movie), err)
+				continue
+			}
+			if err := UpdateJsonDB(DBPath, movieId, LMovie); err != nil {
+				report.fail("Unable to update json DB: %s", err)
+				continue
+			}
+			db.Movies = append(db.Movies, movieId)
+		}
+		report.AddedMovies = append(report.AddedMovies, movieLabel(movie))
+	}
+
+	for tmdbId, show := range traktSyncShows(report) {
+		showId := strconv.Itoa(tmdbId)
+		fromTrakt["show." + showId] = true
+		if inLibrary["show." + showId] {
+			continue
+		}
+		if dryRun == false {
+			if err := WriteShowStrm(showId, ShowsLibraryPath); err != nil {
+				report.fail("Unable to add show %s: %s", showLabel(show), err)
+				continue
+			}
+			if err := UpdateJsonDB(DBPath, showId, LShow); err != nil {
+				report.fail("Unable to update json DB: %s", err)
+				continue
+			}
+			db.Shows = append(db.Shows, showId)
+		}
+		report.AddedShows = append(report.AddedShows, showLabel(show))
+	}
+
+	if config.Get().TraktSyncPush {
+		pushLibrary(db, fromTrakt, report)
+	}
+
+	if dryRun == false && len(report.AddedMovies) + len(report.AddedShows) > 0 {
+		xbmc.VideoLibraryScan()
+	}
+	libraryLog.Infof("Library synced with Trakt: %d movies and %d shows added, %d movies and %d shows pushed",
+		len(report.AddedMovies), len(report.AddedShows), len(report.PushedMovies), len(report.PushedShows))
+	return report, nil
+}
+
+// LibrarySyncLoop runs SyncLibrary every trakt_sync hours, 0 disabling it.
+func LibrarySyncLoop() {
+	ticker := time.NewTicker(librarySyncCheck)
+	defer ticker.Stop()
+	for range ticker.C {
+		interval := time.Duration(config.Get().TraktSyncInterval) * time.Hour
+		librarySyncLock.Lock()
+		last := lastLibrarySync
+		librarySyncLock.Unlock()
+		if interval <= 0 || time.Since(last) < interval || trakt.Authorized() == false {
+			continue
+		}
+		if _, err := SyncLibrary(false); err != nil {
+			libraryLog.Errorf("Unable to sync library with Trakt: %s", err)
+		}
+	}
+}
+
+func SyncLibraryWithTrakt(ctx *gin.Context) {
+	report, err := SyncLibrary(false)
+	if err == errInvalidLibraryPath {
+		xbmc.Notify("Quasar", "LOCALIZE[30220]", config.AddonIcon())
+		ctx.String(404, "")
+		return
+	} else if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	if len(report.Errors) > 0 {
+		xbmc.Notify("Quasar", "LOCALIZE[30324]", config.AddonIcon())
+	} else {
+		xbmc.Notify("Quasar", fmt.Sprintf("LOCALIZE[30325] %d", len(report.AddedMovies) + len(report.AddedShows)), config.AddonIcon())
+	}
+	ctx.JSON(200, report)
+}
+
+// SyncLibraryDryRun reports what SyncLibraryWithTrakt would change without
+// touching the library or Trakt.
+func SyncLibraryDryRun(ctx *gin.Context) {
+	report, err := SyncLibrary(true)
+	if err == errInvalidLibraryPath {
+		xbmc.Notify("Quasar", "LOCALIZE[30220]", config.AddonIcon())
+		ctx.String(404, "")
+		return
+	} else if err != nil {
+		traktError(ctx, err)
+		return
+	}
+
+	lines := make([]string, 0)
+	for _, title := range append(report.AddedMovies, report.AddedShows...) {
+		libraryLog.Infof("Sync would add %s to the library", title)
+		lines = append(lines, fmt.Sprintf("LOCALIZE[30330] %s", title))
+	}
+	for _, title := range append(report.PushedMovies, report.PushedShows...) {
+		libraryLog.Infof("Sync would push %s to Trakt", title)
+		lines = append(lines, fmt.Sprintf("LOCALIZE[30331] %s", title))
+	}
+	for _, title := range report.NotPushed {
+		libraryLog.Infof("Sync would not push %s, it comes from Trakt", title)
+	}
+	for _, message := range report.Errors {
+		lines = append(lines, fmt.Sprintf("[COLOR red]%s[/COLOR]", message))
+	}
+	if len(lines) == 0 {
+		xbmc.Notify("Quasar", "LOCALIZE[30332]", config.AddonIcon())
+	} else {
+		xbmc.ListDialogLarge("LOCALIZE[30329]", "", lines...)
+	}
+	ctx.JSON(200, report)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
@@ -51,6 +52,7 @@ var genreSlugs = map[int]string{
 func MoviesIndex(ctx *gin.Context) {
 	items := xbmc.ListItems{
 		{Label: "LOCALIZE[30209]", Path: UrlForXBMC("/movies/search"), Thumbnail: config.AddonResource("img", "search.png")},
+		{Label: "LOCALIZE[30327]", Path: UrlForXBMC("/movies/search/people"), Thumbnail: config.AddonResource("img", "search.png")},
 		{Label: "LOCALIZE[30210]", Path: UrlForXBMC("/movies/popular"), Thumbnail: config.AddonResource("img", "popular.png")},
 		{Label: "LOCALIZE[30236]", Path: UrlForXBMC("/movies/recent"), Thumbnail: config.AddonResource("img", "clock.png")},
 		{Label: "LOCALIZE[30211]", Path: UrlForXBMC("/movies/top"), Thumbnail: config.AddonResource("img", "top_rated.png")},
@@ -64,7 +66,21 @@ func MoviesIndex(ctx *gin.Context) {
 		{Label: "LOCALIZE[30251]", Path: UrlForXBMC("/movies/trakt/boxoffice"), Thumbnail: config.AddonResource("img", "trakt.png")},
 		{Label: "LOCALIZE[30213]", Path: UrlForXBMC("/movies/imdb250"), Thumbnail: config.AddonResource("img", "imdb.png")},
 	}
-	for _, genre := range tmdb.GetMovieGenres(config.Get().Language) {
+	if trakt.Authorized() {
+		items = append(items, xbmc.ListItems{
+			{Label: "LOCALIZE[30313]", Path: UrlForXBMC("/movies/trakt/watchlist"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30314]", Path: UrlForXBMC("/movies/trakt/collection"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30315]", Path: UrlForXBMC("/movies/trakt/recommendations"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30316]", Path: UrlForXBMC("/movies/trakt/history"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30317]", Path: UrlForXBMC("/movies/trakt/lists"), Thumbnail: config.AddonResource("img", "trakt.png")},
+		}...)
+	}
+	genres, err := tmdb.GetMovieGenres(config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	for _, genre := range genres {
 		slug, _ := genreSlugs[genre.Id]
 		items = append(items, &xbmc.ListItem{
 			Label:     genre.Name,
@@ -100,9 +116,11 @@ func renderMovies(movies tmdb.Movies, ctx *gin.Context, page int) {
 			[]string{"LOCALIZE[30202]", fmt.Sprintf("XBMC.PlayMedia(%s)", movieLinksUrl)},
 			[]string{"LOCALIZE[30023]", fmt.Sprintf("XBMC.PlayMedia(%s)", playUrl)},
 			[]string{"LOCALIZE[30203]", "XBMC.Action(Info)"},
+			[]string{"LOCALIZE[30328]", fmt.Sprintf("Container.Update(%s)", UrlForXBMC("/movie/%d/cast", movie.Id))},
 			[]string{"LOCALIZE[30219]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/addremove/%d", movie.Id))},
 			[]string{"LOCALIZE[30034]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/movies"))},
 		}
+		setMovieWatched(item, movie.Id)
 		item.Info.Trailer = UrlForHTTP("/youtube/%s", item.Info.Trailer)
 		item.IsPlayable = true
 		items = append(items, item)
@@ -131,7 +149,12 @@ func PopularMovies(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderMovies(tmdb.PopularMoviesComplete(genre, config.Get().Language, page), ctx, page)
+	movies, err := tmdb.PopularMoviesComplete(genre, config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, page)
 }
 
 func RecentMovies(ctx *gin.Context) {
@@ -146,7 +169,12 @@ func RecentMovies(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderMovies(tmdb.RecentMoviesComplete(genre, config.Get().Language, page), ctx, page)
+	movies, err := tmdb.RecentMoviesComplete(genre, config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, page)
 }
 
 func TopRatedMovies(ctx *gin.Context) {
@@ -161,11 +189,21 @@ func TopRatedMovies(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderMovies(tmdb.TopRatedMoviesComplete(genre, config.Get().Language, page), ctx, page)
+	movies, err := tmdb.TopRatedMoviesComplete(genre, config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, page)
 }
 
 func IMDBTop250(ctx *gin.Context) {
-	renderMovies(tmdb.GetList("522effe419c2955e9922fcf3", config.Get().Language), ctx, -1)
+	movies, err := tmdb.GetList("522effe419c2955e9922fcf3", config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, -1)
 }
 
 func MoviesMostVoted(ctx *gin.Context) {
@@ -176,7 +214,12 @@ func MoviesMostVoted(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderMovies(tmdb.MostVotedMoviesComplete("", config.Get().Language, page), ctx, page)
+	movies, err := tmdb.MostVotedMoviesComplete("", config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, page)
 }
 
 func SearchMovies(ctx *gin.Context) {
@@ -187,11 +230,20 @@ func SearchMovies(ctx *gin.Context) {
 			return
 		}
 	}
-	renderMovies(tmdb.SearchMovies(query, config.Get().Language), ctx, -1)
+	movies, err := tmdb.SearchMovies(query, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, -1)
 }
 
 func MovieGenres(ctx *gin.Context) {
-	genres := tmdb.GetMovieGenres(config.Get().Language)
+	genres, err := tmdb.GetMovieGenres(config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 	items := make(xbmc.ListItems, 0, len(genres))
 	for _, genre := range genres {
 		items = append(items, &xbmc.ListItem{
@@ -203,10 +255,13 @@ func MovieGenres(ctx *gin.Context) {
 	ctx.JSON(200, xbmc.NewView("", items))
 }
 
-func movieLinks(tmdbId string) ([]*bittorrent.Torrent, string) {
+func movieLinks(tmdbId string) ([]*bittorrent.Torrent, string, error) {
 	log.Println("Searching links for:", tmdbId)
 
-	movie := tmdb.GetMovieById(tmdbId, config.Get().Language)
+	movie, err := tmdb.GetMovieById(tmdbId, config.Get().Language)
+	if err != nil {
+		return nil, "", err
+	}
 
 	log.Printf("Resolved %s to %s", tmdbId, movie.Title)
 
@@ -215,11 +270,15 @@ func movieLinks(tmdbId string) ([]*bittorrent.Torrent, string) {
 		xbmc.Notify("Quasar", "LOCALIZE[30204]", config.AddonIcon())
 	}
 
-	return providers.SearchMovie(searchers, movie), movie.Title
+	return providers.SearchMovie(searchers, movie), movie.Title, nil
 }
 
 func MovieLinks(ctx *gin.Context) {
-	torrents, movieTitle := movieLinks(ctx.Params.ByName("tmdbId"))
+	torrents, movieTitle, err := movieLinks(ctx.Params.ByName("tmdbId"))
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 
 	if len(torrents) == 0 {
 		xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
@@ -269,18 +328,22 @@ func MovieLinks(ctx *gin.Context) {
 
 	choice := xbmc.ListDialogLarge("LOCALIZE[30228]", movieTitle, choices...)
 	if choice >= 0 {
-		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].Magnet())
+		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].Magnet(), "tmdb", ctx.Params.ByName("tmdbId"))
 		ctx.Redirect(302, rUrl)
 	}
 }
 
 func MoviePlay(ctx *gin.Context) {
-	torrents, _ := movieLinks(ctx.Params.ByName("tmdbId"))
+	torrents, _, err := movieLinks(ctx.Params.ByName("tmdbId"))
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 	if len(torrents) == 0 {
 		xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
 		return
 	}
 	sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
-	rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet())
+	rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet(), "tmdb", ctx.Params.ByName("tmdbId"))
 	ctx.Redirect(302, rUrl)
 }
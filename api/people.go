@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+const (
+	personCreditsPerPage = 20
+)
+
+func renderCredits(credits *tmdb.Credits, ctx *gin.Context) {
+	if credits == nil {
+		credits = &tmdb.Credits{}
+	}
+	items := make(xbmc.ListItems, 0, len(credits.Cast) + len(credits.Crew))
+	sort.Sort(tmdb.CastByOrder(credits.Cast))
+	for _, member := range credits.Cast {
+		item := member.ToListItem()
+		item.Path = UrlForXBMC("/person/%d", member.Id)
+		items = append(items, item)
+	}
+	for _, member := range credits.CrewByPerson() {
+		item := member.ToListItem()
+		item.Path = UrlForXBMC("/person/%d", member.Id)
+		items = append(items, item)
+	}
+	ctx.JSON(200, xbmc.NewView("", items))
+}
+
+func MovieCast(ctx *gin.Context) {
+	movie, err := tmdb.GetMovieById(ctx.Params.ByName("tmdbId"), config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderCredits(movie.Credits, ctx)
+}
+
+func ShowCast(ctx *gin.Context) {
+	showId, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+	show, err := tmdb.GetShow(showId, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderCredits(show.Credits, ctx)
+}
+
+func getPerson(ctx *gin.Context) *tmdb.Person {
+	personId, _ := strconv.Atoi(ctx.Params.ByName("personId"))
+	person, err := tmdb.GetPerson(personId, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return nil
+	}
+	return person
+}
+
+func PersonIndex(ctx *gin.Context) {
+	person := getPerson(ctx)
+	if person == nil {
+		return
+	}
+	items := make(xbmc.ListItems, 0, 2)
+	if movieIds := person.MovieCredits.Ids(); len(movieIds) > 0 {
+		item := person.ToListItem()
+		item.Label = fmt.Sprintf("LOCALIZE[30214] (%d)", len(movieIds))
+		item.Path = UrlForXBMC("/person/%d/movies", person.Id)
+		items = append(items, item)
+	}
+	if showIds := person.TVCredits.Ids(); len(showIds) > 0 {
+		item := person.ToListItem()
+		item.Label = fmt.Sprintf("LOCALIZE[30215] (%d)", len(showIds))
+		item.Path = UrlForXBMC("/person/%d/shows", person.Id)
+		items = append(items, item)
+	}
+	ctx.JSON(200, xbmc.NewView("", items))
+}
+
+// creditsPage returns the ids of the requested page of a filmography, and
+// that page again if there are more after it, -1 otherwise.
+func creditsPage(ids []int, ctx *gin.Context) ([]int, int) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "0"))
+	start := page * personCreditsPerPage
+	if start < 0 || start >= len(ids) {
+		return []int{}, -1
+	}
+	end := start + personCreditsPerPage
+	if end >= len(ids) {
+		return ids[start:], -1
+	}
+	return ids[start:end], page
+}
+
+func PersonMovies(ctx *gin.Context) {
+	person := getPerson(ctx)
+	if person == nil {
+		return
+	}
+	movieIds, page := creditsPage(person.MovieCredits.Ids(), ctx)
+	renderMovies(tmdb.GetMovies(movieIds, config.Get().Language), ctx, page)
+}
+
+func PersonShows(ctx *gin.Context) {
+	person := getPerson(ctx)
+	if person == nil {
+		return
+	}
+	showIds, page := creditsPage(person.TVCredits.Ids(), ctx)
+	renderShows(tmdb.GetShows(showIds, config.Get().Language), ctx, page)
+}
+
+func SearchPeople(ctx *gin.Context) {
+	query := ctx.Request.URL.Query().Get("q")
+	if query == "" {
+		query = xbmc.Keyboard("", "LOCALIZE[30327]")
+		if query == "" {
+			return
+		}
+	}
+	people, err := tmdb.SearchPeople(query, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	items := make(xbmc.ListItems, 0, len(people))
+	for _, person := range people {
+		item := person.ToListItem()
+		item.Path = UrlForXBMC("/person/%d", person.Id)
+		items = append(items, item)
+	}
+	ctx.JSON(200, xbmc.NewView("", items))
+}
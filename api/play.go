@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 )
@@ -53,6 +54,9 @@ func Play(btService *bittorrent.BTService) gin.HandlerFunc {
 		}
 
 		player := bittorrent.NewBTPlayer(btService, magnet, fileIndex, resumeIndex, infoHash)
+		if scrobble := playScrobble(ctx); scrobble != nil {
+			player.SetScrobble(scrobble)
+		}
 		if player.Buffer() != nil {
 			return
 		}
@@ -62,6 +66,25 @@ func Play(btService *bittorrent.BTService) gin.HandlerFunc {
 	}
 }
 
+// playScrobble tells what's being played from the ids MoviePlay and
+// ShowEpisodePlay add to the play URL.
+func playScrobble(ctx *gin.Context) *trakt.Scrobble {
+	query := ctx.Request.URL.Query()
+	if tmdbId, err := strconv.Atoi(query.Get("tmdb")); err == nil {
+		return trakt.MovieScrobble(tmdbId)
+	}
+	showId, err := strconv.Atoi(query.Get("show"))
+	if err != nil {
+		return nil
+	}
+	season, _ := strconv.Atoi(query.Get("season"))
+	episode, err := strconv.Atoi(query.Get("episode"))
+	if err != nil {
+		return nil
+	}
+	return trakt.EpisodeScrobble(showId, season, episode)
+}
+
 func PasteURL(ctx *gin.Context) {
 	retval := xbmc.DialogInsert()
 	if retval["path"] == "" {
@@ -22,7 +22,11 @@ func ProviderGetMovie(ctx *gin.Context) {
 	tmdbId := ctx.Params.ByName("tmdbId")
 	provider := ctx.Params.ByName("provider")
 	log.Println("Searching links for:", tmdbId)
-	movie := tmdb.GetMovieById(tmdbId, "en")
+	movie, err := tmdb.GetMovieById(tmdbId, "en")
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 	log.Printf("Resolved %s to %s", tmdbId, movie.Title)
 
 	searcher := providers.NewAddonSearcher(provider)
@@ -51,10 +55,18 @@ func ProviderGetEpisode(ctx *gin.Context) {
 
 	log.Println("Searching links for TMDB Id:", showId)
 
-	show := tmdb.GetShow(showId, "en")
-	season := tmdb.GetSeason(showId, seasonNumber, "en")
-	if season == nil {
-		ctx.Error(errors.New(fmt.Sprintf("Unable to get season %d", seasonNumber)))
+	show, err := tmdb.GetShow(showId, "en")
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	season, err := tmdb.GetSeason(showId, seasonNumber, "en")
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	if episodeNumber < 1 || episodeNumber > len(season.Episodes) {
+		ctx.Error(errors.New(fmt.Sprintf("Unable to get episode %d of season %d", episodeNumber, seasonNumber)))
 		return
 	}
 	episode := season.Episodes[episodeNumber - 1]
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,6 +13,7 @@ import (
 	"github.com/scakemyer/quasar/cache"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/providers"
+	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/util"
 )
 
@@ -27,7 +29,7 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 
 	gin.SetMode(gin.ReleaseMode)
 
-	store := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	store := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
 
 	r.GET("/", Index)
 	r.GET("/search", Search)
@@ -41,61 +43,96 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		torrents.GET("/pause/:torrentId", PauseTorrent(btService))
 		torrents.GET("/resume/:torrentId", ResumeTorrent(btService))
 		torrents.GET("/delete/:torrentId", RemoveTorrent(btService))
+		torrents.POST("/add", AddTorrent(btService))
+		torrents.GET("/export/:infoHash", ExportTorrent(btService))
+		torrents.GET("/altspeed", AltSpeedStatus(btService))
+		torrents.GET("/altspeed/toggle", ToggleAltSpeed(btService))
+		torrents.GET("/altspeed/auto", AutoAltSpeed(btService))
 	}
 
 	movies := r.Group("/movies")
 	{
-		movies.GET("/", cache.Cache(store, IndexCacheTime), MoviesIndex)
-		movies.GET("/search", SearchMovies)
-		movies.GET("/popular", cache.Cache(store, DefaultCacheTime), PopularMovies)
-		movies.GET("/popular/:genre", cache.Cache(store, DefaultCacheTime), PopularMovies)
-		movies.GET("/recent", cache.Cache(store, DefaultCacheTime), RecentMovies)
-		movies.GET("/recent/:genre", cache.Cache(store, DefaultCacheTime), RecentMovies)
-		movies.GET("/top", cache.Cache(store, DefaultCacheTime), TopRatedMovies)
-		movies.GET("/imdb250", cache.Cache(store, DefaultCacheTime), IMDBTop250)
-		movies.GET("/mostvoted", cache.Cache(store, DefaultCacheTime), MoviesMostVoted)
-		movies.GET("/genres", cache.Cache(store, IndexCacheTime), MovieGenres)
-		movies.GET("/trakt/popular", cache.Cache(store, DefaultCacheTime), TraktPopularMovies)
-		movies.GET("/trakt/trending", cache.Cache(store, DefaultCacheTime), TraktTrendingMovies)
-		movies.GET("/trakt/played", cache.Cache(store, DefaultCacheTime), TraktMostPlayedMovies)
-		movies.GET("/trakt/watched", cache.Cache(store, DefaultCacheTime), TraktMostWatchedMovies)
-		movies.GET("/trakt/collected", cache.Cache(store, DefaultCacheTime), TraktMostCollectedMovies)
-		movies.GET("/trakt/anticipated", cache.Cache(store, DefaultCacheTime), TraktMostAnticipatedMovies)
-		movies.GET("/trakt/boxoffice", cache.Cache(store, DefaultCacheTime), TraktBoxOffice)
+		movies.GET("/", cache.Cache(store, IndexCacheTime, varyLanguage, varyTraktAccount), MoviesIndex)
+		movies.GET("/search", watchedOverlay, SearchMovies)
+		movies.GET("/search/people", SearchPeople)
+		movies.GET("/popular", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), PopularMovies)
+		movies.GET("/popular/:genre", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), PopularMovies)
+		movies.GET("/recent", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), RecentMovies)
+		movies.GET("/recent/:genre", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), RecentMovies)
+		movies.GET("/top", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), TopRatedMovies)
+		movies.GET("/imdb250", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), IMDBTop250)
+		movies.GET("/mostvoted", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), MoviesMostVoted)
+		movies.GET("/genres", cache.Cache(store, IndexCacheTime, varyLanguage), MovieGenres)
+		movies.GET("/trakt/popular", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktPopularMovies)
+		movies.GET("/trakt/trending", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktTrendingMovies)
+		movies.GET("/trakt/played", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostPlayedMovies)
+		movies.GET("/trakt/watched", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostWatchedMovies)
+		movies.GET("/trakt/collected", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostCollectedMovies)
+		movies.GET("/trakt/anticipated", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostAnticipatedMovies)
+		movies.GET("/trakt/boxoffice", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktBoxOffice)
+		movies.GET("/trakt/watchlist", watchedOverlay, TraktWatchlistMovies)
+		movies.GET("/trakt/collection", watchedOverlay, TraktCollectionMovies)
+		movies.GET("/trakt/recommendations", watchedOverlay, TraktRecommendedMovies)
+		movies.GET("/trakt/history", watchedOverlay, TraktHistoryMovies)
+		movies.GET("/trakt/lists", TraktLists("movies"))
+		movies.GET("/trakt/lists/:listId", watchedOverlay, TraktListMovies)
 	}
 	movie := r.Group("/movie")
 	{
 		movie.GET("/:tmdbId/links", MovieLinks)
 		movie.GET("/:tmdbId/play", MoviePlay)
+		movie.GET("/:tmdbId/cast", cache.Cache(store, DefaultCacheTime, varyLanguage), MovieCast)
+		movie.GET("/:tmdbId/watched", MarkMovie(true))
+		movie.GET("/:tmdbId/unwatched", MarkMovie(false))
 	}
 
 	shows := r.Group("/shows")
 	{
-		shows.GET("/", cache.Cache(store, IndexCacheTime), TVIndex)
-		shows.GET("/search", SearchShows)
-		shows.GET("/popular", cache.Cache(store, DefaultCacheTime), PopularShows)
-		shows.GET("/popular/:genre", cache.Cache(store, DefaultCacheTime), PopularShows)
-		shows.GET("/recent/shows", cache.Cache(store, DefaultCacheTime), RecentShows)
-		shows.GET("/recent/shows/:genre", cache.Cache(store, DefaultCacheTime), RecentShows)
-		shows.GET("/recent/episodes", cache.Cache(store, DefaultCacheTime), RecentEpisodes)
-		shows.GET("/recent/episodes/:genre", cache.Cache(store, DefaultCacheTime), RecentEpisodes)
-		shows.GET("/top", cache.Cache(store, DefaultCacheTime), TopRatedShows)
-		shows.GET("/mostvoted", cache.Cache(store, DefaultCacheTime), TVMostVoted)
-		shows.GET("/genres", cache.Cache(store, IndexCacheTime), TVGenres)
-		shows.GET("/trakt/popular", cache.Cache(store, DefaultCacheTime), TraktPopularShows)
-		shows.GET("/trakt/trending", cache.Cache(store, DefaultCacheTime), TraktTrendingShows)
-		shows.GET("/trakt/played", cache.Cache(store, DefaultCacheTime), TraktMostPlayedShows)
-		shows.GET("/trakt/watched", cache.Cache(store, DefaultCacheTime), TraktMostWatchedShows)
-		shows.GET("/trakt/collected", cache.Cache(store, DefaultCacheTime), TraktMostCollectedShows)
-		shows.GET("/trakt/anticipated", cache.Cache(store, DefaultCacheTime), TraktMostAnticipatedShows)
+		shows.GET("/", cache.Cache(store, IndexCacheTime, varyLanguage, varyTraktAccount), TVIndex)
+		shows.GET("/search", watchedOverlay, SearchShows)
+		shows.GET("/search/people", SearchPeople)
+		shows.GET("/popular", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), PopularShows)
+		shows.GET("/popular/:genre", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), PopularShows)
+		shows.GET("/recent/shows", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), RecentShows)
+		shows.GET("/recent/shows/:genre", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), RecentShows)
+		shows.GET("/recent/episodes", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), RecentEpisodes)
+		shows.GET("/recent/episodes/:genre", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), RecentEpisodes)
+		shows.GET("/top", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), TopRatedShows)
+		shows.GET("/mostvoted", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), TVMostVoted)
+		shows.GET("/genres", cache.Cache(store, IndexCacheTime, varyLanguage), TVGenres)
+		shows.GET("/trakt/popular", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktPopularShows)
+		shows.GET("/trakt/trending", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktTrendingShows)
+		shows.GET("/trakt/played", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostPlayedShows)
+		shows.GET("/trakt/watched", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostWatchedShows)
+		shows.GET("/trakt/collected", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostCollectedShows)
+		shows.GET("/trakt/anticipated", watchedOverlay, cache.CacheWithPrefix(store, cache.TraktPageCachePrefix, DefaultCacheTime, varyLanguage, varyPagination), TraktMostAnticipatedShows)
+		shows.GET("/trakt/watchlist", watchedOverlay, TraktWatchlistShows)
+		shows.GET("/trakt/collection", watchedOverlay, TraktCollectionShows)
+		shows.GET("/trakt/recommendations", watchedOverlay, TraktRecommendedShows)
+		shows.GET("/trakt/history", watchedOverlay, TraktHistoryShows)
+		shows.GET("/trakt/lists", TraktLists("shows"))
+		shows.GET("/trakt/lists/:listId", watchedOverlay, TraktListShows)
+		shows.GET("/trakt/calendar", watchedOverlay, TraktCalendarShows)
 	}
 	show := r.Group("/show")
 	{
-		show.GET("/:showId/seasons", cache.Cache(store, DefaultCacheTime), ShowSeasons)
+		show.GET("/:showId/seasons", cache.Cache(store, DefaultCacheTime, varyLanguage), ShowSeasons)
 		show.GET("/:showId/season/:season/links", ShowSeasonLinks)
-		show.GET("/:showId/season/:season/episodes", cache.Cache(store, EpisodesCacheTime), ShowEpisodes)
+		show.GET("/:showId/season/:season/episodes", watchedOverlay, cache.Cache(store, EpisodesCacheTime, varyLanguage), ShowEpisodes)
 		show.GET("/:showId/season/:season/episode/:episode/play", ShowEpisodePlay)
 		show.GET("/:showId/season/:season/episode/:episode/links", ShowEpisodeLinks)
+		show.GET("/:showId/season/:season/episode/:episode/watched", MarkEpisode(true))
+		show.GET("/:showId/season/:season/episode/:episode/unwatched", MarkEpisode(false))
+		show.GET("/:showId/cast", cache.Cache(store, DefaultCacheTime, varyLanguage), ShowCast)
+		show.GET("/:showId/watched", MarkShow(true))
+		show.GET("/:showId/unwatched", MarkShow(false))
+	}
+
+	person := r.Group("/person")
+	{
+		person.GET("/:personId", cache.Cache(store, DefaultCacheTime, varyLanguage), PersonIndex)
+		person.GET("/:personId/movies", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), PersonMovies)
+		person.GET("/:personId/shows", watchedOverlay, cache.Cache(store, DefaultCacheTime, varyLanguage, varyPagination), PersonShows)
 	}
 
 	library := r.Group("/library")
@@ -107,6 +144,8 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		library.GET("/show/remove/:showId", RemoveShow)
 		library.GET("/show/addremove/:showId", AddRemoveShow)
 		library.GET("/update", UpdateLibrary)
+		library.GET("/sync", SyncLibraryWithTrakt)
+		library.GET("/sync/dryrun", SyncLibraryDryRun)
 		library.GET("/getpath", GetLibraryPath)
 		library.GET("/getcount", GetCount)
 		library.GET("/lookup", Lookup)
@@ -144,14 +183,44 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 
 	r.POST("/callbacks/:cid", providers.CallbackHandler)
 
+	traktGroup := r.Group("/trakt")
+	{
+		traktGroup.GET("/authorize", AuthorizeTrakt)
+		traktGroup.GET("/unlink", UnlinkTrakt)
+		traktGroup.GET("/watchlist/:type/add/:tmdbId", TraktAddToWatchlist)
+		traktGroup.GET("/watchlist/:type/remove/:tmdbId", TraktRemoveFromWatchlist)
+	}
+
 	cmd := r.Group("/cmd")
 	{
 		cmd.GET("/clear_cache", ClearCache)
+		cmd.GET("/purge_cache/:namespace", PurgeCache(store))
+	}
+
+	debug := r.Group("/debug")
+	{
+		debug.GET("/cache", CacheStats(store))
 	}
 
 	return r
 }
 
+func varyLanguage(ctx *gin.Context) string {
+	return config.Get().Language
+}
+
+// varyTraktAccount keeps the menus with personal Trakt entries apart.
+func varyTraktAccount(ctx *gin.Context) string {
+	if trakt.Authorized() {
+		return "trakt"
+	}
+	return ""
+}
+
+func varyPagination(ctx *gin.Context) string {
+	return strconv.FormatBool(config.Get().EnablePagination)
+}
+
 func UrlForHTTP(pattern string, args ...interface{}) string {
 	u, _ := url.Parse(fmt.Sprintf(pattern, args...))
 	return util.GetHTTPHost() + u.String()
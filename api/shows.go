@@ -3,16 +3,18 @@ package api
 import (
 	"fmt"
 	"log"
-	"errors"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/artwork"
 	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
@@ -23,6 +25,7 @@ var (
 func TVIndex(ctx *gin.Context) {
 	items := xbmc.ListItems{
 		{Label: "LOCALIZE[30209]", Path: UrlForXBMC("/shows/search"), Thumbnail: config.AddonResource("img", "search.png")},
+		{Label: "LOCALIZE[30327]", Path: UrlForXBMC("/shows/search/people"), Thumbnail: config.AddonResource("img", "search.png")},
 		{Label: "LOCALIZE[30210]", Path: UrlForXBMC("/shows/popular"), Thumbnail: config.AddonResource("img", "popular.png")},
 		{Label: "LOCALIZE[30237]", Path: UrlForXBMC("/shows/recent/shows"), Thumbnail: config.AddonResource("img", "clock.png")},
 		{Label: "LOCALIZE[30238]", Path: UrlForXBMC("/shows/recent/episodes"), Thumbnail: config.AddonResource("img", "fresh.png")},
@@ -33,7 +36,22 @@ func TVIndex(ctx *gin.Context) {
 		{Label: "LOCALIZE[30249]", Path: UrlForXBMC("/shows/trakt/collected"), Thumbnail: config.AddonResource("img", "trakt.png")},
 		{Label: "LOCALIZE[30250]", Path: UrlForXBMC("/shows/trakt/anticipated"), Thumbnail: config.AddonResource("img", "trakt.png")},
 	}
-	for _, genre := range tmdb.GetTVGenres(config.Get().Language) {
+	if trakt.Authorized() {
+		items = append(items, xbmc.ListItems{
+			{Label: "LOCALIZE[30313]", Path: UrlForXBMC("/shows/trakt/watchlist"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30314]", Path: UrlForXBMC("/shows/trakt/collection"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30315]", Path: UrlForXBMC("/shows/trakt/recommendations"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30316]", Path: UrlForXBMC("/shows/trakt/history"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30317]", Path: UrlForXBMC("/shows/trakt/lists"), Thumbnail: config.AddonResource("img", "trakt.png")},
+			{Label: "LOCALIZE[30318]", Path: UrlForXBMC("/shows/trakt/calendar"), Thumbnail: config.AddonResource("img", "trakt.png")},
+		}...)
+	}
+	genres, err := tmdb.GetTVGenres(config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	for _, genre := range genres {
 		slug, _ := genreSlugs[genre.Id]
 		items = append(items, &xbmc.ListItem{
 			Label:     genre.Name,
@@ -50,7 +68,11 @@ func TVIndex(ctx *gin.Context) {
 }
 
 func TVGenres(ctx *gin.Context) {
-	genres := tmdb.GetTVGenres(config.Get().Language)
+	genres, err := tmdb.GetTVGenres(config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 	items := make(xbmc.ListItems, 0, len(genres))
 	for _, genre := range genres {
 		items = append(items, &xbmc.ListItem{
@@ -76,8 +98,10 @@ func renderShows(shows tmdb.Shows, ctx *gin.Context, page int) {
 		item.Path = UrlForXBMC("/show/%d/seasons", show.Id)
 		item.ContextMenu = [][]string{
 			[]string{"LOCALIZE[30219]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/addremove/%d", show.Id))},
+			[]string{"LOCALIZE[30328]", fmt.Sprintf("Container.Update(%s)", UrlForXBMC("/show/%d/cast", show.Id))},
 			[]string{"LOCALIZE[30035]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/tvshows"))},
 		}
+		setShowWatched(item, show.Id, show.NumberOfEpisodes)
 		items = append(items, item)
 	}
 	if page >= 0 {
@@ -104,7 +128,12 @@ func PopularShows(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderShows(tmdb.PopularShowsComplete(genre, config.Get().Language, page), ctx, page)
+	shows, err := tmdb.PopularShowsComplete(genre, config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderShows(shows, ctx, page)
 }
 
 func RecentShows(ctx *gin.Context) {
@@ -119,7 +148,12 @@ func RecentShows(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderShows(tmdb.RecentShowsComplete(genre, config.Get().Language, page), ctx, page)
+	shows, err := tmdb.RecentShowsComplete(genre, config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderShows(shows, ctx, page)
 }
 
 func RecentEpisodes(ctx *gin.Context) {
@@ -134,7 +168,12 @@ func RecentEpisodes(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderShows(tmdb.RecentEpisodesComplete(genre, config.Get().Language, page), ctx, page)
+	shows, err := tmdb.RecentEpisodesComplete(genre, config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderShows(shows, ctx, page)
 }
 
 func TopRatedShows(ctx *gin.Context) {
@@ -145,7 +184,12 @@ func TopRatedShows(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderShows(tmdb.TopRatedShowsComplete("", config.Get().Language, page), ctx, page)
+	shows, err := tmdb.TopRatedShowsComplete("", config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderShows(shows, ctx, page)
 }
 
 func TVMostVoted(ctx *gin.Context) {
@@ -156,7 +200,12 @@ func TVMostVoted(ctx *gin.Context) {
 			page = currentpage
 		}
 	}
-	renderMovies(tmdb.MostVotedShowsComplete("", config.Get().Language, page), ctx, page)
+	movies, err := tmdb.MostVotedShowsComplete("", config.Get().Language, page)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderMovies(movies, ctx, page)
 }
 
 func SearchShows(ctx *gin.Context) {
@@ -167,13 +216,22 @@ func SearchShows(ctx *gin.Context) {
 			return
 		}
 	}
-	renderShows(tmdb.SearchShows(query, config.Get().Language), ctx, -1)
+	shows, err := tmdb.SearchShows(query, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
+	renderShows(shows, ctx, -1)
 }
 
 func ShowSeasons(ctx *gin.Context) {
 	showId, _ := strconv.Atoi(ctx.Params.ByName("showId"))
 
-	show := tmdb.GetShow(showId, config.Get().Language)
+	show, err := tmdb.GetShow(showId, config.Get().Language)
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 
 	items := show.Seasons.ToListItems(show)
 	reversedItems := make(xbmc.ListItems, 0)
@@ -195,8 +253,29 @@ func ShowEpisodes(ctx *gin.Context) {
 	showId, _ := strconv.Atoi(ctx.Params.ByName("showId"))
 	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
 	language := config.Get().Language
-	show := tmdb.GetShow(showId, language)
-	season := tmdb.GetSeason(showId, seasonNumber, language)
+
+	// Get the season while the show and its artwork are fetched
+	var show *tmdb.Show
+	var showErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		show, showErr = tmdb.GetShow(showId, language)
+		if showErr == nil && show.ExternalIDs != nil {
+			artwork.PrefetchShow(show.ExternalIDs.TVDBID)
+		}
+	}()
+	season, err := tmdb.GetSeason(showId, seasonNumber, language)
+	wg.Wait()
+	if showErr != nil {
+		tmdbError(ctx, showErr)
+		return
+	}
+	if err != nil {
+		tmdbError(ctx, err)
+		return
+	}
 	items := season.Episodes.ToListItems(show, season)
 
 	for _, item := range items {
@@ -221,6 +300,7 @@ func ShowEpisodes(ctx *gin.Context) {
 			[]string{"LOCALIZE[30203]", "XBMC.Action(Info)"},
 			[]string{"LOCALIZE[30037]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/episodes"))},
 		}
+		setEpisodeWatched(item, show.Id, seasonNumber, item.Info.Episode)
 		item.IsPlayable = true
 	}
 
@@ -230,10 +310,13 @@ func ShowEpisodes(ctx *gin.Context) {
 func showSeasonLinks(showId int, seasonNumber int) ([]*bittorrent.Torrent, string, error) {
 	log.Println("Searching links for TMDB Id:", showId)
 
-	show := tmdb.GetShow(showId, config.Get().Language)
-	season := tmdb.GetSeason(showId, seasonNumber, config.Get().Language)
-	if season == nil {
-		return nil, "", errors.New("Unable to find season")
+	show, err := tmdb.GetShow(showId, config.Get().Language)
+	if err != nil {
+		return nil, "", err
+	}
+	season, err := tmdb.GetSeason(showId, seasonNumber, config.Get().Language)
+	if err != nil {
+		return nil, "", err
 	}
 
 	log.Printf("Resolved %d to %s", showId, show.Name)
@@ -253,7 +336,7 @@ func ShowSeasonLinks(ctx *gin.Context) {
 	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
 	torrents, longName, err := showSeasonLinks(showId, seasonNumber)
 	if err != nil {
-		ctx.Error(err)
+		tmdbError(ctx, err)
 		return
 	}
 
@@ -313,12 +396,18 @@ func ShowSeasonLinks(ctx *gin.Context) {
 func showEpisodeLinks(showId int, seasonNumber int, episodeNumber int) ([]*bittorrent.Torrent, string, error) {
 	log.Println("Searching links for TMDB Id:", showId)
 
-	show := tmdb.GetShow(showId, config.Get().Language)
-	season := tmdb.GetSeason(showId, seasonNumber, config.Get().Language)
-	if season == nil {
-		return nil, "", errors.New("Unable to find season")
+	show, err := tmdb.GetShow(showId, config.Get().Language)
+	if err != nil {
+		return nil, "", err
+	}
+	season, err := tmdb.GetSeason(showId, seasonNumber, config.Get().Language)
+	if err != nil {
+		return nil, "", err
 	}
 
+	if episodeNumber < 1 || episodeNumber > len(season.Episodes) {
+		return nil, "", fmt.Errorf("Unable to find episode %d of season %d", episodeNumber, seasonNumber)
+	}
 	episode := season.Episodes[episodeNumber - 1]
 
 	log.Printf("Resolved %d to %s", showId, show.Name)
@@ -339,7 +428,7 @@ func ShowEpisodeLinks(ctx *gin.Context) {
 	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
 	torrents, longName, err := showEpisodeLinks(showId, seasonNumber, episodeNumber)
 	if err != nil {
-		ctx.Error(err)
+		tmdbError(ctx, err)
 		return
 	}
 
@@ -391,7 +480,10 @@ func ShowEpisodeLinks(ctx *gin.Context) {
 
 	choice := xbmc.ListDialogLarge("LOCALIZE[30228]", longName, choices...)
 	if choice >= 0 {
-		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].Magnet())
+		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].Magnet(),
+			"show", strconv.Itoa(showId),
+			"season", strconv.Itoa(seasonNumber),
+			"episode", strconv.Itoa(episodeNumber))
 		ctx.Redirect(302, rUrl)
 	}
 }
@@ -402,7 +494,7 @@ func ShowEpisodePlay(ctx *gin.Context) {
 	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
 	torrents, _, err := showEpisodeLinks(showId, seasonNumber, episodeNumber)
 	if err != nil {
-		ctx.Error(err)
+		tmdbError(ctx, err)
 		return
 	}
 
@@ -411,6 +503,9 @@ func ShowEpisodePlay(ctx *gin.Context) {
 		return
 	}
 
-	rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet())
+	rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet(),
+		"show", strconv.Itoa(showId),
+		"season", strconv.Itoa(seasonNumber),
+		"episode", strconv.Itoa(episodeNumber))
 	ctx.Redirect(302, rUrl)
 }
@@ -7,7 +7,6 @@ import (
 	"strconv"
 	"strings"
 	"net/url"
-	"net/http"
 	"compress/gzip"
 	"path/filepath"
 
@@ -180,7 +179,7 @@ func SubtitleGet(ctx *gin.Context) {
 	file := q.Get("file")
 	dl := q.Get("dl")
 
-	resp, err := http.Get(dl)
+	resp, err := util.NewHTTPClient(0).Get(dl)
 	if err != nil {
 		ctx.AbortWithError(500, err)
 		return
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+var tmdbLog = logging.MustGetLogger("tmdb")
+
+// tmdbError tells the user why a TMDB backed page failed instead of leaving
+// an empty directory, the invalid API key having its own message.
+func tmdbError(ctx *gin.Context, err error) {
+	tmdbLog.Errorf("TMDB request for %s failed: %s", ctx.Request.URL.Path, err)
+	if err == tmdb.ErrInvalidAPIKey {
+		xbmc.Notify("Quasar", "LOCALIZE[30306]", config.AddonIcon())
+	} else {
+		xbmc.Notify("Quasar", "LOCALIZE[30307]", config.AddonIcon())
+	}
+	ctx.Error(err)
+	ctx.String(500, "")
+}
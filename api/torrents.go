@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"errors"
 	"strconv"
+	"io/ioutil"
 	"encoding/hex"
 	"path/filepath"
 
@@ -58,6 +59,8 @@ func ListTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
 				[]string{"LOCALIZE[30232]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/torrents/delete/%d", i))},
 				[]string{"LOCALIZE[30233]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/torrents/pause"))},
 				[]string{"LOCALIZE[30234]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/torrents/resume"))},
+				[]string{"LOCALIZE[30300]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/torrents/altspeed/toggle"))},
+				[]string{"LOCALIZE[30301]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/torrents/altspeed/auto"))},
 			}
 			item.IsPlayable = true
 			items = append(items, &item)
@@ -169,3 +172,100 @@ func RemoveTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		ctx.String(200, "")
 	}
 }
+
+// AddTorrent accepts a .torrent upload ("file") or a magnet/URL ("uri") and
+// either plays it or queues it for background download ("action=queue").
+func AddTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uri := ctx.Request.FormValue("uri")
+		action := ctx.Request.FormValue("action")
+
+		if file, header, err := ctx.Request.FormFile("file"); err == nil {
+			defer file.Close()
+			data, err := ioutil.ReadAll(file)
+			if err != nil {
+				ctx.AbortWithError(400, err)
+				return
+			}
+			infoHash, err := btService.ImportTorrentData(data)
+			if err != nil {
+				torrentsLog.Errorf("Invalid torrent file %s: %s", header.Filename, err)
+				ctx.AbortWithError(400, err)
+				return
+			}
+			torrentsLog.Infof("Imported %s as %s", header.Filename, infoHash)
+			uri = fmt.Sprintf("magnet:?xt=urn:btih:%s", infoHash)
+		}
+
+		if uri == "" {
+			ctx.AbortWithError(400, errors.New("Missing torrent file or URI"))
+			return
+		}
+
+		if action == "queue" {
+			infoHash, err := btService.QueueTorrent(uri)
+			if err != nil {
+				ctx.AbortWithError(500, err)
+				return
+			}
+			ctx.JSON(200, gin.H{"info_hash": infoHash, "action": "queue"})
+			return
+		}
+
+		// .torrent URLs only have an info-hash once downloaded
+		torrent := bittorrent.NewTorrent(uri)
+		if err := torrent.Resolve(); err != nil {
+			torrentsLog.Errorf("Unable to resolve %s: %s", uri, err)
+			ctx.AbortWithError(400, err)
+			return
+		}
+		xbmc.PlayURL(UrlQuery(UrlForXBMC("/play"), "uri", uri))
+		ctx.JSON(200, gin.H{"info_hash": torrent.InfoHash, "action": "play"})
+	}
+}
+
+// ExportTorrent serves the .torrent metadata of a torrent by info-hash.
+func ExportTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash, err := bittorrent.ParseInfoHash(ctx.Params.ByName("infoHash"))
+		if err != nil {
+			ctx.AbortWithError(400, err)
+			return
+		}
+		data, err := btService.ExportTorrentData(infoHash)
+		if err != nil {
+			torrentsLog.Error(err)
+			ctx.AbortWithError(404, err)
+			return
+		}
+		ctx.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.torrent\"", infoHash))
+		ctx.Data(200, "application/x-bittorrent", data)
+	}
+}
+
+func AltSpeedStatus(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{"alt_speed": btService.AltSpeedActive()})
+	}
+}
+
+func ToggleAltSpeed(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if btService.ToggleAltSpeed() {
+			torrentsLog.Info("Alternative speed enabled")
+			xbmc.Notify("Quasar", "LOCALIZE[30302]", config.AddonIcon())
+		} else {
+			torrentsLog.Info("Alternative speed disabled")
+			xbmc.Notify("Quasar", "LOCALIZE[30303]", config.AddonIcon())
+		}
+		ctx.JSON(200, gin.H{"alt_speed": btService.AltSpeedActive()})
+	}
+}
+
+func AutoAltSpeed(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		btService.SetAltSpeedOverride(bittorrent.AltSpeedAuto)
+		torrentsLog.Info("Alternative speed follows the bandwidth schedule")
+		ctx.JSON(200, gin.H{"alt_speed": btService.AltSpeedActive()})
+	}
+}
@@ -2,17 +2,38 @@ package api
 
 import (
 	"fmt"
+	"time"
+	"errors"
 	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
-func renderTraktMovies(movies []*trakt.Movies, ctx *gin.Context, page int) {
+var (
+	traktLog = logging.MustGetLogger("trakt")
+
+	traktAuthorizing     = false
+	traktAuthorizingLock = sync.Mutex{}
+)
+
+// traktNextPage links to the page after the given one, telling how many
+// there are.
+func traktNextPage(ctx *gin.Context, pagination *trakt.Pagination) *xbmc.ListItem {
+	return &xbmc.ListItem{
+		Label: fmt.Sprintf("LOCALIZE[30218] (%d/%d)", pagination.Page + 1, pagination.PageCount),
+		Path: UrlForXBMC(fmt.Sprintf("%s?page=%d", ctx.Request.URL.Path, pagination.Page + 1)),
+		Thumbnail: config.AddonResource("img", "nextpage.png"),
+	}
+}
+
+func renderTraktMovies(movies []*trakt.Movies, ctx *gin.Context, pagination *trakt.Pagination) {
 	hasNextPage := 0
-	if page > 0 {
+	if pagination.HasNext() {
 		hasNextPage = 1
 	}
 
@@ -36,68 +57,105 @@ func renderTraktMovies(movies []*trakt.Movies, ctx *gin.Context, page int) {
 			[]string{"LOCALIZE[30023]", fmt.Sprintf("XBMC.PlayMedia(%s)", playUrl)},
 			[]string{"LOCALIZE[30203]", "XBMC.Action(Info)"},
 			[]string{"LOCALIZE[30219]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/addremove/%d", movie.IDs.TMDB))},
+			[]string{"LOCALIZE[30319]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/trakt/watchlist/movies/add/%d", movie.IDs.TMDB))},
+			[]string{"LOCALIZE[30320]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/trakt/watchlist/movies/remove/%d", movie.IDs.TMDB))},
 			[]string{"LOCALIZE[30034]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/movies"))},
 		}
+		setMovieWatched(item, movie.IDs.TMDB)
 		// item.Info.Trailer = UrlForHTTP("/youtube/%s", movie.Trailer)
 		item.IsPlayable = true
 		items = append(items, item)
 	}
-	if page >= 0 {
-		path := ctx.Request.URL.Path
-		nextpage := &xbmc.ListItem{
-			Label: "LOCALIZE[30218]",
-			Path: UrlForXBMC(fmt.Sprintf("%s?page=%d", path, page + 1)),
-			Thumbnail: config.AddonResource("img", "nextpage.png"),
-		}
-		items = append(items, nextpage)
+	if pagination.HasNext() {
+		items = append(items, traktNextPage(ctx, pagination))
 	}
 	ctx.JSON(200, xbmc.NewView("movies", items))
 }
 
 func TraktPopularMovies(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktMovies(trakt.TopMovies("popular", pageParam), ctx, page)
+	movies, pagination := trakt.TopMovies("popular", ctx.DefaultQuery("page", "1"))
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 func TraktTrendingMovies(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktMovies(trakt.TopMovies("trending", pageParam), ctx, page)
+	movies, pagination := trakt.TopMovies("trending", ctx.DefaultQuery("page", "1"))
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 func TraktMostPlayedMovies(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktMovies(trakt.TopMovies("played", pageParam), ctx, page)
+	movies, pagination := trakt.TopMovies("played", ctx.DefaultQuery("page", "1"))
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 func TraktMostWatchedMovies(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktMovies(trakt.TopMovies("watched", pageParam), ctx, page)
+	movies, pagination := trakt.TopMovies("watched", ctx.DefaultQuery("page", "1"))
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 func TraktMostCollectedMovies(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktMovies(trakt.TopMovies("collected", pageParam), ctx, page)
+	movies, pagination := trakt.TopMovies("collected", ctx.DefaultQuery("page", "1"))
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 func TraktMostAnticipatedMovies(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktMovies(trakt.TopMovies("anticipated", pageParam), ctx, page)
+	movies, pagination := trakt.TopMovies("anticipated", ctx.DefaultQuery("page", "1"))
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 func TraktBoxOffice(ctx *gin.Context) {
-	renderTraktMovies(trakt.TopMovies("boxoffice", "1"), ctx, 0)
+	movies, pagination := trakt.TopMovies("boxoffice", "1")
+	renderTraktMovies(movies, ctx, pagination)
 }
 
 
-func renderTraktShows(shows []*trakt.Shows, ctx *gin.Context, page int) {
+func TraktWatchlistMovies(ctx *gin.Context) {
+	movies, err := trakt.WatchlistMovies()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktMovies(movies, ctx, nil)
+}
+
+func TraktCollectionMovies(ctx *gin.Context) {
+	movies, err := trakt.CollectionMovies()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktMovies(movies, ctx, nil)
+}
+
+func TraktRecommendedMovies(ctx *gin.Context) {
+	movies, err := trakt.RecommendedMovies()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktMovies(movies, ctx, nil)
+}
+
+func TraktHistoryMovies(ctx *gin.Context) {
+	movies, pagination, err := trakt.HistoryMovies(ctx.DefaultQuery("page", "1"))
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktMovies(movies, ctx, pagination)
+}
+
+func TraktListMovies(ctx *gin.Context) {
+	movies, err := trakt.ListMovies(ctx.Params.ByName("listId"))
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktMovies(movies, ctx, nil)
+}
+
+func renderTraktShows(shows []*trakt.Shows, ctx *gin.Context, pagination *trakt.Pagination) {
 	hasNextPage := 0
-	if page > 0 {
+	if pagination.HasNext() {
 		hasNextPage = 1
 	}
 
@@ -112,54 +170,263 @@ func renderTraktShows(shows []*trakt.Shows, ctx *gin.Context, page int) {
 		item.Path = UrlForXBMC("/show/%d/seasons", show.IDs.TMDB)
 		item.ContextMenu = [][]string{
 			[]string{"LOCALIZE[30219]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/addremove/%d", show.IDs.TMDB))},
+			[]string{"LOCALIZE[30319]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/trakt/watchlist/shows/add/%d", show.IDs.TMDB))},
+			[]string{"LOCALIZE[30320]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/trakt/watchlist/shows/remove/%d", show.IDs.TMDB))},
 			[]string{"LOCALIZE[30035]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/tvshows"))},
 		}
+		setShowWatched(item, show.IDs.TMDB, show.AiredEpisodes)
 		items = append(items, item)
 	}
-	if page >= 0 {
-		path := ctx.Request.URL.Path
-		nextpage := &xbmc.ListItem{
-			Label: "LOCALIZE[30218]",
-			Path: UrlForXBMC(fmt.Sprintf("%s?page=%d", path, page + 1)),
-			Thumbnail: config.AddonResource("img", "nextpage.png"),
-		}
-		items = append(items, nextpage)
+	if pagination.HasNext() {
+		items = append(items, traktNextPage(ctx, pagination))
 	}
 	ctx.JSON(200, xbmc.NewView("tvshows", items))
 }
 
 func TraktPopularShows(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktShows(trakt.TopShows("popular", pageParam), ctx, page)
+	shows, pagination := trakt.TopShows("popular", ctx.DefaultQuery("page", "1"))
+	renderTraktShows(shows, ctx, pagination)
 }
 
 func TraktTrendingShows(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktShows(trakt.TopShows("trending", pageParam), ctx, page)
+	shows, pagination := trakt.TopShows("trending", ctx.DefaultQuery("page", "1"))
+	renderTraktShows(shows, ctx, pagination)
 }
 
 func TraktMostPlayedShows(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktShows(trakt.TopShows("played", pageParam), ctx, page)
+	shows, pagination := trakt.TopShows("played", ctx.DefaultQuery("page", "1"))
+	renderTraktShows(shows, ctx, pagination)
 }
 
 func TraktMostWatchedShows(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktShows(trakt.TopShows("watched", pageParam), ctx, page)
+	shows, pagination := trakt.TopShows("watched", ctx.DefaultQuery("page", "1"))
+	renderTraktShows(shows, ctx, pagination)
 }
 
 func TraktMostCollectedShows(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktShows(trakt.TopShows("collected", pageParam), ctx, page)
+	shows, pagination := trakt.TopShows("collected", ctx.DefaultQuery("page", "1"))
+	renderTraktShows(shows, ctx, pagination)
 }
 
 func TraktMostAnticipatedShows(ctx *gin.Context) {
-	pageParam := ctx.DefaultQuery("page", "1")
-	page, _ := strconv.Atoi(pageParam)
-	renderTraktShows(trakt.TopShows("anticipated", pageParam), ctx, page)
+	shows, pagination := trakt.TopShows("anticipated", ctx.DefaultQuery("page", "1"))
+	renderTraktShows(shows, ctx, pagination)
+}
+
+func TraktWatchlistShows(ctx *gin.Context) {
+	shows, err := trakt.WatchlistShows()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktShows(shows, ctx, nil)
+}
+
+func TraktCollectionShows(ctx *gin.Context) {
+	shows, err := trakt.CollectionShows()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktShows(shows, ctx, nil)
+}
+
+func TraktRecommendedShows(ctx *gin.Context) {
+	shows, err := trakt.RecommendedShows()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktShows(shows, ctx, nil)
+}
+
+func TraktHistoryShows(ctx *gin.Context) {
+	shows, pagination, err := trakt.HistoryShows(ctx.DefaultQuery("page", "1"))
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktShows(shows, ctx, pagination)
+}
+
+func TraktListShows(ctx *gin.Context) {
+	shows, err := trakt.ListShows(ctx.Params.ByName("listId"))
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	renderTraktShows(shows, ctx, nil)
+}
+
+// TraktLists lists the account's custom lists, linking to their movies or
+// shows depending on the section it's browsed from.
+func TraktLists(section string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		lists, err := trakt.Lists()
+		if err != nil {
+			traktError(ctx, err)
+			return
+		}
+		items := make(xbmc.ListItems, 0, len(lists))
+		for _, list := range lists {
+			items = append(items, &xbmc.ListItem{
+				Label: fmt.Sprintf("%s (%d)", list.Name, list.ItemCount),
+				Info: &xbmc.ListItemInfo{
+					Plot: list.Description,
+				},
+				Path: UrlForXBMC("/%s/trakt/lists/%d", section, list.IDs.Trakt),
+				Thumbnail: config.AddonResource("img", "trakt.png"),
+			})
+		}
+		ctx.JSON(200, xbmc.NewView("", items))
+	}
+}
+
+func TraktCalendarShows(ctx *gin.Context) {
+	calendar, err := trakt.CalendarShows()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+
+	items := make(xbmc.ListItems, 0, len(calendar))
+	for _, entry := range calendar {
+		show := entry.Show
+		episode := entry.Episode
+		if show == nil || episode == nil {
+			continue
+		}
+		aired, _ := time.Parse(time.RFC3339, entry.FirstAired)
+
+		item := episode.ToListItem()
+		item.Label = fmt.Sprintf("%s %s - %s", aired.Local().Format("Mon 02/01"), show.Title, item.Label)
+		item.Info.TVShowTitle = show.Title
+		item.Info.Aired = aired.Local().Format("2006-01-02")
+		if show.Images != nil && show.Images.FanArt != nil {
+			item.Art.FanArt = show.Images.FanArt.Full
+		}
+
+		playUrl := UrlForXBMC("/show/%d/season/%d/episode/%d/play", show.IDs.TMDB, episode.Season, episode.Number)
+		episodeLinksUrl := UrlForXBMC("/show/%d/season/%d/episode/%d/links", show.IDs.TMDB, episode.Season, episode.Number)
+		if config.Get().ChooseStreamAuto == true {
+			item.Path = playUrl
+		} else {
+			item.Path = episodeLinksUrl
+		}
+		item.ContextMenu = [][]string{
+			[]string{"LOCALIZE[30202]", fmt.Sprintf("XBMC.PlayMedia(%s)", episodeLinksUrl)},
+			[]string{"LOCALIZE[30023]", fmt.Sprintf("XBMC.PlayMedia(%s)", playUrl)},
+			[]string{"LOCALIZE[30203]", "XBMC.Action(Info)"},
+			[]string{"LOCALIZE[30037]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/episodes"))},
+		}
+		setEpisodeWatched(item, show.IDs.TMDB, episode.Season, episode.Number)
+		item.IsPlayable = true
+		items = append(items, item)
+	}
+	ctx.JSON(200, xbmc.NewView("episodes", items))
+}
+
+func traktItem(ctx *gin.Context) (string, int, error) {
+	itemType := ctx.Params.ByName("type")
+	if itemType != "movies" && itemType != "shows" {
+		return "", 0, fmt.Errorf("Unknown Trakt item type %s", itemType)
+	}
+	tmdbId, err := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	return itemType, tmdbId, err
+}
+
+func TraktAddToWatchlist(ctx *gin.Context) {
+	itemType, tmdbId, err := traktItem(ctx)
+	if err == nil {
+		err = trakt.AddToWatchlist(itemType, tmdbId)
+	}
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	xbmc.Notify("Quasar", "LOCALIZE[30322]", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func TraktRemoveFromWatchlist(ctx *gin.Context) {
+	itemType, tmdbId, err := traktItem(ctx)
+	if err == nil {
+		err = trakt.RemoveFromWatchlist(itemType, tmdbId)
+	}
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	xbmc.Notify("Quasar", "LOCALIZE[30322]", config.AddonIcon())
+	xbmc.Refresh()
+	ctx.String(200, "")
+}
+
+// traktError tells the user why a personal Trakt page or action failed,
+// asking to link an account when none is.
+func traktError(ctx *gin.Context, err error) {
+	traktLog.Errorf("Trakt request for %s failed: %s", ctx.Request.URL.Path, err)
+	if err == trakt.ErrNotAuthorized {
+		xbmc.Notify("Quasar", "LOCALIZE[30323]", config.AddonIcon())
+	} else {
+		xbmc.Notify("Quasar", "LOCALIZE[30324]", config.AddonIcon())
+	}
+	ctx.Error(err)
+	ctx.String(500, "")
+}
+
+// AuthorizeTrakt shows the code to enter on Trakt, and waits for the user
+// in the background so the plugin isn't blocked while they do.
+func AuthorizeTrakt(ctx *gin.Context) {
+	traktAuthorizingLock.Lock()
+	defer traktAuthorizingLock.Unlock()
+	if traktAuthorizing {
+		ctx.String(409, "")
+		return
+	}
+
+	code, err := trakt.GetCode()
+	if err != nil {
+		xbmc.Notify("Quasar", "LOCALIZE[30311]", config.AddonIcon())
+		ctx.Error(err)
+		return
+	}
+
+	dialog := xbmc.NewDialogProgress("Trakt",
+		fmt.Sprintf("LOCALIZE[30308] [B]%s[/B]", code.VerificationURL),
+		fmt.Sprintf("LOCALIZE[30309] [B]%s[/B]", code.UserCode),
+		"")
+	if dialog == nil {
+		ctx.Error(errors.New("Unable to open dialog"))
+		return
+	}
+
+	traktAuthorizing = true
+	go func() {
+		defer func() {
+			dialog.Close()
+			traktAuthorizingLock.Lock()
+			traktAuthorizing = false
+			traktAuthorizingLock.Unlock()
+		}()
+
+		if err := trakt.Authorize(code, dialog.IsCanceled); err != nil {
+			if err != trakt.ErrAuthCancelled {
+				traktLog.Errorf("Unable to link Trakt account: %s", err)
+				xbmc.Notify("Quasar", "LOCALIZE[30311]", config.AddonIcon())
+			}
+			return
+		}
+		xbmc.Notify("Quasar", "LOCALIZE[30310]", config.AddonIcon())
+	}()
+	ctx.String(200, "")
+}
+
+func UnlinkTrakt(ctx *gin.Context) {
+	if err := trakt.Unlink(); err != nil {
+		ctx.Error(err)
+		return
+	}
+	xbmc.Notify("Quasar", "LOCALIZE[30312]", config.AddonIcon())
+	ctx.String(200, "")
 }
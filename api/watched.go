@@ -0,0 +1,515 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"regexp"
+	"strconv"
+	"strings"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+const (
+	watchedRefresh  = 5 * time.Minute
+	watchedProperty = "quasar.watched"
+)
+
+var (
+	watchedLog = logging.MustGetLogger("watched")
+
+	strmMovieRegexp   = regexp.MustCompile(`/library/play/movie/(\d+)`)
+	strmEpisodeRegexp = regexp.MustCompile(`/library/play/show/(\d+)/season/(\d+)/episode/(\d+)`)
+
+	watched        *watchedState
+	watchedLoading = false
+	watchedLock    = sync.Mutex{}
+
+	// What the .strm files of the library play, by path
+	strmTargets     = map[string]string{}
+	strmTargetsLock = sync.Mutex{}
+)
+
+type watchedEntry struct {
+	PlayCount  int     `json:"playcount"`
+	ResumeTime float64 `json:"resume_time"`
+	TotalTime  float64 `json:"total_time"`
+	kodiIds    []int
+}
+
+// watchedState is what the user has seen, merged from the Kodi library and
+// the Trakt history, by TMDB id.
+type watchedState struct {
+	Movies   map[int]*watchedEntry    `json:"movies"`
+	Episodes map[string]*watchedEntry `json:"episodes"`
+	Shows    map[int]int              `json:"shows"`
+	updated  time.Time
+}
+
+func episodeKey(showId int, season int, episode int) string {
+	return fmt.Sprintf("%d.%d.%d", showId, season, episode)
+}
+
+func (w *watchedState) movie(tmdbId int) *watchedEntry {
+	entry, ok := w.Movies[tmdbId]
+	if !ok {
+		entry = &watchedEntry{}
+		w.Movies[tmdbId] = entry
+	}
+	return entry
+}
+
+func (w *watchedState) episode(showId int, season int, episode int) *watchedEntry {
+	key := episodeKey(showId, season, episode)
+	entry, ok := w.Episodes[key]
+	if !ok {
+		entry = &watchedEntry{}
+		w.Episodes[key] = entry
+	}
+	return entry
+}
+
+// strmTarget returns the plugin URL a .strm file of the library plays. Kodi
+// only gives us the path of the file, the ids are in its contents.
+func strmTarget(file string) string {
+	if strings.HasSuffix(file, ".strm") == false {
+		return ""
+	}
+	strmTargetsLock.Lock()
+	defer strmTargetsLock.Unlock()
+	if target, ok := strmTargets[file]; ok {
+		return target
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	strmTargets[file] = strings.TrimSpace(string(data))
+	return strmTargets[file]
+}
+
+func (w *watchedState) addKodi() {
+	movies, err := xbmc.VideoLibraryGetMovies()
+	if err != nil {
+		watchedLog.Warningf("Unable to get movies from the Kodi library: %s", err)
+	}
+	for _, movie := range movies {
+		match := strmMovieRegexp.FindStringSubmatch(strmTarget(movie.File))
+		if match == nil {
+			continue
+		}
+		tmdbId, _ := strconv.Atoi(match[1])
+		entry := w.movie(tmdbId)
+		entry.kodiIds = append(entry.kodiIds, movie.ID)
+		if movie.PlayCount > entry.PlayCount {
+			entry.PlayCount = movie.PlayCount
+		}
+		if movie.Resume != nil && movie.Resume.Position > 0 {
+			entry.ResumeTime = movie.Resume.Position
+			entry.TotalTime = movie.Resume.Total
+		}
+	}
+
+	episodes, err := xbmc.VideoLibraryGetEpisodes()
+	if err != nil {
+		watchedLog.Warningf("Unable to get episodes from the Kodi library: %s", err)
+	}
+	for _, episode := range episodes {
+		match := strmEpisodeRegexp.FindStringSubmatch(strmTarget(episode.File))
+		if match == nil {
+			continue
+		}
+		showId, _ := strconv.Atoi(match[1])
+		season, _ := strconv.Atoi(match[2])
+		number, _ := strconv.Atoi(match[3])
+		entry := w.episode(showId, season, number)
+		entry.kodiIds = append(entry.kodiIds, episode.ID)
+		if episode.PlayCount > entry.PlayCount {
+			entry.PlayCount = episode.PlayCount
+		}
+		if episode.Resume != nil && episode.Resume.Position > 0 {
+			entry.ResumeTime = episode.Resume.Position
+			entry.TotalTime = episode.Resume.Total
+		}
+	}
+}
+
+func (w *watchedState) addTrakt() {
+	if trakt.Authorized() == false {
+		return
+	}
+	movies, err := trakt.WatchedMovies()
+	if err != nil {
+		watchedLog.Warningf("Unable to get watched movies from Trakt: %s", err)
+	}
+	for _, watchedMovie := range movies {
+		if watchedMovie.Movie == nil || watchedMovie.Movie.IDs == nil || watchedMovie.Movie.IDs.TMDB == 0 {
+			continue
+		}
+		entry := w.movie(watchedMovie.Movie.IDs.TMDB)
+		if watchedMovie.Plays > entry.PlayCount {
+			entry.PlayCount = watchedMovie.Plays
+		}
+	}
+
+	shows, err := trakt.WatchedShows()
+	if err != nil {
+		watchedLog.Warningf("Unable to get watched shows from Trakt: %s", err)
+	}
+	for _, watchedShow := range shows {
+		if watchedShow.Show == nil || watchedShow.Show.IDs == nil || watchedShow.Show.IDs.TMDB == 0 {
+			continue
+		}
+		for _, season := range watchedShow.Seasons {
+			for _, episode := range season.Episodes {
+				entry := w.episode(watchedShow.Show.IDs.TMDB, season.Number, episode.Number)
+				if episode.Plays > entry.PlayCount {
+					entry.PlayCount = episode.Plays
+				}
+			}
+		}
+	}
+}
+
+// countShows counts the watched episodes of each show, specials aside.
+func (w *watchedState) countShows() {
+	for key, entry := range w.Episodes {
+		var showId, season, episode int
+		fmt.Sscanf(key, "%d.%d.%d", &showId, &season, &episode)
+		if entry.PlayCount > 0 && season > 0 {
+			w.Shows[showId]++
+		}
+	}
+}
+
+func newWatchedState() *watchedState {
+	return &watchedState{
+		Movies:   make(map[int]*watchedEntry),
+		Episodes: make(map[string]*watchedEntry),
+		Shows:    make(map[int]int),
+		updated:  time.Now(),
+	}
+}
+
+func loadWatched() *watchedState {
+	w := newWatchedState()
+	w.addKodi()
+	w.addTrakt()
+	w.countShows()
+	return w
+}
+
+func reloadWatched() {
+	w := loadWatched()
+	watchedLock.Lock()
+	defer watchedLock.Unlock()
+	watched = w
+	watchedLoading = false
+}
+
+// getWatched returns the last watched state without waiting for Kodi or
+// Trakt, reloading it in the background when it's older than watchedRefresh.
+// The state is never modified once loaded, it's replaced.
+func getWatched() *watchedState {
+	watchedLock.Lock()
+	defer watchedLock.Unlock()
+	if (watched == nil || time.Since(watched.updated) > watchedRefresh) && watchedLoading == false {
+		watchedLoading = true
+		go reloadWatched()
+	}
+	if watched == nil {
+		return newWatchedState()
+	}
+	return watched
+}
+
+// loadedWatched is like getWatched but waits for the first load, for the
+// actions that need the Kodi ids.
+func loadedWatched() *watchedState {
+	watchedLock.Lock()
+	w := watched
+	watchedLock.Unlock()
+	if w == nil {
+		w = loadWatched()
+		watchedLock.Lock()
+		watched = w
+		watchedLock.Unlock()
+	}
+	return w
+}
+
+func setWatched(item *xbmc.ListItem, entry *watchedEntry) {
+	if entry == nil || item.Info == nil {
+		return
+	}
+	if entry.PlayCount > 0 {
+		item.Info.PlayCount = entry.PlayCount
+		item.Info.Overlay = xbmc.IconOverlayWatched
+	}
+	if entry.ResumeTime > 0 {
+		if item.Properties == nil {
+			item.Properties = make(map[string]string)
+		}
+		item.Properties["ResumeTime"] = strconv.FormatFloat(entry.ResumeTime, 'f', 0, 64)
+		item.Properties["TotalTime"] = strconv.FormatFloat(entry.TotalTime, 'f', 0, 64)
+	}
+}
+
+func watchedMenu(entry *watchedEntry, route string, args ...interface{}) []string {
+	if entry != nil && entry.PlayCount > 0 {
+		return []string{"LOCALIZE[30326]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC(route + "/unwatched", args...))}
+	}
+	return []string{"LOCALIZE[30321]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC(route + "/watched", args...))}
+}
+
+// The set*Watched functions only tag list items with what they are, the
+// watched state is applied by watchedOverlay so that cached pages don't
+// depend on it.
+func tagWatched(item *xbmc.ListItem, format string, args ...interface{}) {
+	if item.Properties == nil {
+		item.Properties = make(map[string]string)
+	}
+	item.Properties[watchedProperty] = fmt.Sprintf(format, args...)
+}
+
+func setMovieWatched(item *xbmc.ListItem, tmdbId int) {
+	tagWatched(item, "movie %d", tmdbId)
+}
+
+func setEpisodeWatched(item *xbmc.ListItem, showId int, season int, episode int) {
+	tagWatched(item, "episode %d %d %d", showId, season, episode)
+}
+
+func setShowWatched(item *xbmc.ListItem, showId int, totalEpisodes int) {
+	tagWatched(item, "show %d %d", showId, totalEpisodes)
+}
+
+// apply sets the watched state of a tagged list item and adds the context
+// menu action to toggle it.
+func (w *watchedState) apply(item *xbmc.ListItem) {
+	tag, ok := item.Properties[watchedProperty]
+	if !ok {
+		return
+	}
+	delete(item.Properties, watchedProperty)
+
+	var kind string
+	var id, season, episode int
+	fmt.Sscanf(tag, "%s %d %d %d", &kind, &id, &season, &episode)
+	switch kind {
+	case "movie":
+		entry := w.Movies[id]
+		setWatched(item, entry)
+		item.ContextMenu = append(item.ContextMenu, watchedMenu(entry, "/movie/%d", id))
+	case "episode":
+		entry := w.Episodes[episodeKey(id, season, episode)]
+		setWatched(item, entry)
+		item.ContextMenu = append(item.ContextMenu, watchedMenu(entry, "/show/%d/season/%d/episode/%d", id, season, episode))
+	case "show":
+		w.applyShow(item, id, season)
+	}
+}
+
+// applyShow marks a show watched once all of its aired episodes are, and
+// sets the episode counts skins show on it.
+func (w *watchedState) applyShow(item *xbmc.ListItem, showId int, totalEpisodes int) {
+	watchedEpisodes := w.Shows[showId]
+	var entry *watchedEntry
+	if totalEpisodes > 0 {
+		if watchedEpisodes > totalEpisodes {
+			watchedEpisodes = totalEpisodes
+		}
+		item.Properties["TotalEpisodes"] = strconv.Itoa(totalEpisodes)
+		item.Properties["WatchedEpisodes"] = strconv.Itoa(watchedEpisodes)
+		item.Properties["UnWatchedEpisodes"] = strconv.Itoa(totalEpisodes - watchedEpisodes)
+		if watchedEpisodes == totalEpisodes {
+			entry = &watchedEntry{PlayCount: 1}
+			setWatched(item, entry)
+		}
+	}
+	item.ContextMenu = append(item.ContextMenu, watchedMenu(entry, "/show/%d", showId))
+}
+
+// watchedWriter holds back the response of a listing until the watched
+// state is applied to it.
+type watchedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *watchedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *watchedWriter) WriteHeaderNow() {
+}
+
+func (w *watchedWriter) Status() int {
+	return w.status
+}
+
+func (w *watchedWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *watchedWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *watchedWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+// watchedOverlay applies the watched state to the listings, cached or not,
+// on their way out. The ETag of the cached page doesn't cover the watched
+// state, so it's computed again over the final listing.
+func watchedOverlay(ctx *gin.Context) {
+	ifNoneMatch := ctx.Request.Header.Get("If-None-Match")
+	ctx.Request.Header.Del("If-None-Match")
+
+	writer := ctx.Writer
+	overlay := &watchedWriter{ResponseWriter: writer}
+	ctx.Writer = overlay
+	ctx.Next()
+	ctx.Writer = writer
+
+	data := overlay.body.Bytes()
+	writer.Header().Del("ETag")
+	writer.Header().Del("Content-Length")
+	if overlay.status == http.StatusOK {
+		var view xbmc.View
+		if err := json.Unmarshal(data, &view); err == nil {
+			w := getWatched()
+			for _, item := range view.Items {
+				w.apply(item)
+			}
+			if overlaid, err := json.Marshal(view); err == nil {
+				data = overlaid
+			}
+		}
+		tag := cache.ETag(data)
+		writer.Header().Set("ETag", tag)
+		if ifNoneMatch != "" && cache.ETagMatches(ifNoneMatch, tag) {
+			writer.WriteHeader(http.StatusNotModified)
+			writer.WriteHeaderNow()
+			return
+		}
+	}
+	if overlay.status != 0 {
+		writer.WriteHeader(overlay.status)
+	}
+	writer.Write(data)
+}
+
+// refreshWatched reloads the watched state right away, after it was changed
+// by the user.
+func refreshWatched() {
+	w := loadWatched()
+	watchedLock.Lock()
+	defer watchedLock.Unlock()
+	watched = w
+}
+
+func markedWatched(ctx *gin.Context, err error) {
+	refreshWatched()
+	if err != nil {
+		traktError(ctx, err)
+		return
+	}
+	xbmc.Refresh()
+	ctx.String(200, "")
+}
+
+func kodiPlayCount(watched bool) int {
+	if watched {
+		return 1
+	}
+	return 0
+}
+
+func MarkMovie(watched bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tmdbId, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+		if entry := loadedWatched().Movies[tmdbId]; entry != nil {
+			for _, movieId := range entry.kodiIds {
+				if err := xbmc.VideoLibrarySetMoviePlayCount(movieId, kodiPlayCount(watched)); err != nil {
+					watchedLog.Errorf("Unable to update movie %d in the Kodi library: %s", movieId, err)
+				}
+			}
+		}
+		var err error
+		if trakt.Authorized() {
+			if watched {
+				err = trakt.MarkWatched("movies", tmdbId)
+			} else {
+				err = trakt.MarkUnwatched("movies", tmdbId)
+			}
+		}
+		markedWatched(ctx, err)
+	}
+}
+
+func MarkEpisode(watched bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		showId, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+		season, _ := strconv.Atoi(ctx.Params.ByName("season"))
+		episode, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+		if entry := loadedWatched().Episodes[episodeKey(showId, season, episode)]; entry != nil {
+			for _, episodeId := range entry.kodiIds {
+				if err := xbmc.VideoLibrarySetEpisodePlayCount(episodeId, kodiPlayCount(watched)); err != nil {
+					watchedLog.Errorf("Unable to update episode %d in the Kodi library: %s", episodeId, err)
+				}
+			}
+		}
+		var err error
+		if trakt.Authorized() {
+			if watched {
+				err = trakt.MarkEpisodeWatched(showId, season, episode)
+			} else {
+				err = trakt.MarkEpisodeUnwatched(showId, season, episode)
+			}
+		}
+		markedWatched(ctx, err)
+	}
+}
+
+// MarkShow marks every episode of a show, Trakt only knowing the aired ones.
+func MarkShow(watched bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		showId, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+		for key, entry := range loadedWatched().Episodes {
+			var entryShowId, season, episode int
+			fmt.Sscanf(key, "%d.%d.%d", &entryShowId, &season, &episode)
+			if entryShowId != showId {
+				continue
+			}
+			for _, episodeId := range entry.kodiIds {
+				if err := xbmc.VideoLibrarySetEpisodePlayCount(episodeId, kodiPlayCount(watched)); err != nil {
+					watchedLog.Errorf("Unable to update episode %d in the Kodi library: %s", episodeId, err)
+				}
+			}
+		}
+		var err error
+		if trakt.Authorized() {
+			if watched {
+				err = trakt.MarkWatched("shows", showId)
+			} else {
+				err = trakt.MarkUnwatched("shows", showId)
+			}
+		}
+		markedWatched(ctx, err)
+	}
+}
@@ -0,0 +1,341 @@
+package artwork
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/fanart"
+	"github.com/scakemyer/quasar/tvdb"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+const (
+	// Failed sources are left alone for a while, instead of being asked
+	// again for every item of a listing.
+	failureCacheTime = 5 * time.Minute
+)
+
+var log = logging.MustGetLogger("artwork")
+
+func failureKey(source string, id int) string {
+	return fmt.Sprintf("com.artwork.failed.%s.%d", source, id)
+}
+
+func failedRecently(source string, id int) bool {
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
+	var failed bool
+	return cacheStore.Get(failureKey(source, id), &failed) == nil
+}
+
+func markFailed(source string, id int) {
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore.Set(failureKey(source, id), true, failureCacheTime)
+}
+
+// candidate is an image from one of the sources, source being its place in
+// the fallback chain and tier how well its language fits.
+type candidate struct {
+	url    string
+	rating float64
+	source int
+	tier   int
+}
+
+// chain collects the candidates of one ListItemArt field, in the order of
+// the sources they're added from.
+type chain struct {
+	language   string
+	textless   bool
+	sources    int
+	candidates []*candidate
+}
+
+func newChain(language string, textless bool) *chain {
+	return &chain{
+		language:   language,
+		textless:   textless,
+		candidates: make([]*candidate, 0),
+	}
+}
+
+func (c *chain) add(url string, imageLanguage string, rating float64) {
+	if url == "" {
+		return
+	}
+	c.candidates = append(c.candidates, &candidate{
+		url:    url,
+		rating: rating,
+		source: c.sources,
+		tier:   tier(imageLanguage, c.language, c.textless),
+	})
+}
+
+func (c *chain) addFanart(images []*fanart.Image) {
+	for _, image := range images {
+		likes, _ := strconv.ParseFloat(image.Likes, 64)
+		c.add(image.URL, image.Lang, likes)
+	}
+	c.sources++
+}
+
+func (c *chain) addTVDB(banners []*tvdb.Banner, match func(*tvdb.Banner) bool) {
+	for _, banner := range banners {
+		if match(banner) == false {
+			continue
+		}
+		rating, _ := strconv.ParseFloat(banner.Rating, 64)
+		c.add(banner.BannerPath, banner.Language, rating)
+	}
+	c.sources++
+}
+
+// tier ranks the language of an image, the user's first, then english and
+// images without text. Backgrounds are better without text at all.
+// The language is regional (pt-BR) but fanart.tv only tags images with the
+// base one (pt).
+func tier(imageLanguage string, language string, textless bool) int {
+	neutral := imageLanguage == "" || imageLanguage == "00"
+	base := language
+	if i := strings.Index(language, "-"); i > 0 {
+		base = language[:i]
+	}
+	switch {
+	case textless && neutral:
+		return 0
+	case imageLanguage == language || imageLanguage == base || imageLanguage == tvdb.LanguageCode(language):
+		return 1
+	case imageLanguage == "en" || imageLanguage == "eng":
+		return 2
+	case neutral:
+		return 3
+	}
+	return 4
+}
+
+// best picks the image in the best language, then from the earliest source,
+// then the best rated.
+func (c *chain) best() string {
+	if len(c.candidates) == 0 {
+		return ""
+	}
+	sort.Stable(byRank(c.candidates))
+	return c.candidates[0].url
+}
+
+type byRank []*candidate
+
+func (a byRank) Len() int      { return len(a) }
+func (a byRank) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRank) Less(i, j int) bool {
+	if a[i].tier != a[j].tier {
+		return a[i].tier < a[j].tier
+	}
+	if a[i].source != a[j].source {
+		return a[i].source < a[j].source
+	}
+	return a[i].rating > a[j].rating
+}
+
+func fill(field *string, c *chain) {
+	if *field == "" {
+		*field = c.best()
+	}
+}
+
+func bannerType(bannerType string) func(*tvdb.Banner) bool {
+	return func(banner *tvdb.Banner) bool {
+		return banner.BannerType == bannerType
+	}
+}
+
+func seasonBanner(season int, bannerType2 string) func(*tvdb.Banner) bool {
+	return func(banner *tvdb.Banner) bool {
+		return banner.BannerType == "season" && banner.BannerType2 == bannerType2 && banner.Season == season
+	}
+}
+
+// ShowSources holds the artwork of a show from every source, so that its
+// seasons and episodes are filled without fetching it again.
+type ShowSources struct {
+	fanart  *fanart.Show
+	banners []*tvdb.Banner
+}
+
+// ForShow fetches the artwork of a show from fanart.tv and TVDB
+// concurrently. Sources that fail are empty.
+func ForShow(tvdbId int) *ShowSources {
+	sources := &ShowSources{fanart: &fanart.Show{}}
+	if tvdbId == 0 {
+		return sources
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if failedRecently("fanart", tvdbId) {
+			return
+		}
+		show, err := fanart.GetShow(tvdbId)
+		if err != nil {
+			if err != fanart.ErrNoAPIKey {
+				log.Warningf("Unable to get fanart.tv artwork of %d: %s", tvdbId, err)
+				markFailed("fanart", tvdbId)
+			}
+			return
+		}
+		sources.fanart = show
+	}()
+	go func() {
+		defer wg.Done()
+		if failedRecently("tvdb", tvdbId) {
+			return
+		}
+		banners, err := tvdb.GetArtworks(strconv.Itoa(tvdbId))
+		if err != nil {
+			if err != tvdb.ErrNoAPIKey {
+				log.Warningf("Unable to get TVDB artwork of %d: %s", tvdbId, err)
+				markFailed("tvdb", tvdbId)
+			}
+			return
+		}
+		sources.banners = banners
+	}()
+	wg.Wait()
+	return sources
+}
+
+func finish(art *xbmc.ListItemArt) {
+	if art.Thumbnail == "" {
+		art.Thumbnail = art.Poster
+	}
+	if art.Landscape == "" {
+		art.Landscape = art.FanArt
+	}
+}
+
+func getMovie(tmdbId int) (*fanart.Movie, error) {
+	if failedRecently("fanart.movie", tmdbId) {
+		return nil, fmt.Errorf("fanart.tv failed recently for movie %d", tmdbId)
+	}
+	movie, err := fanart.GetMovie(tmdbId)
+	if err != nil && err != fanart.ErrNoAPIKey {
+		log.Warningf("Unable to get fanart.tv artwork of movie %d: %s", tmdbId, err)
+		markFailed("fanart.movie", tmdbId)
+	}
+	return movie, err
+}
+
+// Movie fills the empty artwork of a movie from fanart.tv.
+func Movie(tmdbId int, language string, art *xbmc.ListItemArt) {
+	movie, err := getMovie(tmdbId)
+	if err != nil {
+		finish(art)
+		return
+	}
+
+	logo := newChain(language, false)
+	logo.addFanart(movie.HDLogo)
+	logo.addFanart(movie.Logo)
+	clearArt := newChain(language, false)
+	clearArt.addFanart(movie.HDClearArt)
+	clearArt.addFanart(movie.ClearArt)
+	banner := newChain(language, false)
+	banner.addFanart(movie.Banner)
+	landscape := newChain(language, false)
+	landscape.addFanart(movie.Thumb)
+	fanArt := newChain(language, true)
+	fanArt.addFanart(movie.Background)
+	poster := newChain(language, false)
+	poster.addFanart(movie.Poster)
+
+	fill(&art.ClearLogo, logo)
+	fill(&art.ClearArt, clearArt)
+	fill(&art.Banner, banner)
+	fill(&art.Landscape, landscape)
+	fill(&art.FanArt, fanArt)
+	fill(&art.Poster, poster)
+	finish(art)
+}
+
+// Show fills the empty artwork of a show from fanart.tv, then TVDB.
+func (s *ShowSources) Show(language string, art *xbmc.ListItemArt) {
+	show, banners := s.fanart, s.banners
+
+	logo := newChain(language, false)
+	logo.addFanart(show.HDLogo)
+	logo.addFanart(show.Logo)
+	logo.addTVDB(banners, bannerType("clearlogo"))
+	clearArt := newChain(language, false)
+	clearArt.addFanart(show.HDClearArt)
+	clearArt.addFanart(show.ClearArt)
+	clearArt.addTVDB(banners, bannerType("clearart"))
+	banner := newChain(language, false)
+	banner.addFanart(show.Banner)
+	banner.addTVDB(banners, bannerType("series"))
+	landscape := newChain(language, false)
+	landscape.addFanart(show.Thumb)
+	fanArt := newChain(language, true)
+	fanArt.addFanart(show.Background)
+	fanArt.addTVDB(banners, bannerType("fanart"))
+	poster := newChain(language, false)
+	poster.addFanart(show.Poster)
+	poster.addTVDB(banners, bannerType("poster"))
+
+	fill(&art.ClearLogo, logo)
+	fill(&art.ClearArt, clearArt)
+	fill(&art.Banner, banner)
+	fill(&art.Landscape, landscape)
+	fill(&art.FanArt, fanArt)
+	fill(&art.Poster, poster)
+	finish(art)
+}
+
+// Season fills the empty artwork of a season, falling back on the show's.
+func (s *ShowSources) Season(season int, language string, art *xbmc.ListItemArt) {
+	show, banners := s.fanart, s.banners
+
+	banner := newChain(language, false)
+	banner.addFanart(fanart.ForSeason(show.SeasonBanner, season))
+	banner.addTVDB(banners, seasonBanner(season, "seasonwide"))
+	landscape := newChain(language, false)
+	landscape.addFanart(fanart.ForSeason(show.SeasonThumb, season))
+	poster := newChain(language, false)
+	poster.addFanart(fanart.ForSeason(show.SeasonPoster, season))
+	poster.addTVDB(banners, seasonBanner(season, "season"))
+
+	fill(&art.Banner, banner)
+	fill(&art.Landscape, landscape)
+	fill(&art.Poster, poster)
+	s.Show(language, art)
+}
+
+// Show fills the empty artwork of a single show. Listings of many items of
+// the same show should get its ShowSources once instead.
+func Show(tvdbId int, language string, art *xbmc.ListItemArt) {
+	ForShow(tvdbId).Show(language, art)
+}
+
+// Season fills the empty artwork of a single season.
+func Season(tvdbId int, season int, language string, art *xbmc.ListItemArt) {
+	ForShow(tvdbId).Season(season, language, art)
+}
+
+// PrefetchMovie caches the artwork of a movie ahead of Movie, so that lists
+// can fetch it concurrently.
+func PrefetchMovie(tmdbId int) {
+	getMovie(tmdbId)
+}
+
+// PrefetchShow caches the artwork of a show ahead of Show and Season.
+func PrefetchShow(tvdbId int) {
+	ForShow(tvdbId)
+}
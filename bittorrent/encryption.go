@@ -0,0 +1,76 @@
+package bittorrent
+
+import (
+	"time"
+
+	"github.com/scakemyer/libtorrent-go"
+)
+
+const (
+	EncryptionEnabled = iota
+	EncryptionForced
+	EncryptionDisabled
+)
+
+const (
+	EncryptionLevelBoth = iota
+	EncryptionLevelPlaintext
+	EncryptionLevelRC4
+)
+
+const (
+	// How long buffering may go without a single peer before retrying
+	// unencrypted
+	encryptionFallbackDelay = 15 * time.Second
+)
+
+func (s *BTService) configureEncryption() {
+	s.bandwidthLock.Lock()
+	fallback := s.encryptionFallback
+	s.bandwidthLock.Unlock()
+
+	encryptionSettings := libtorrent.NewPeSettings()
+	defer libtorrent.DeletePeSettings(encryptionSettings)
+
+	policy := byte(libtorrent.PeSettingsEnabled)
+	switch s.getConfig().EncryptionPolicy {
+	case EncryptionForced:
+		policy = byte(libtorrent.PeSettingsForced)
+	case EncryptionDisabled:
+		policy = byte(libtorrent.PeSettingsDisabled)
+	}
+	level := byte(libtorrent.PeSettingsBoth)
+	switch s.getConfig().EncryptionLevel {
+	case EncryptionLevelPlaintext:
+		level = byte(libtorrent.PeSettingsPlaintext)
+	case EncryptionLevelRC4:
+		level = byte(libtorrent.PeSettingsRc4)
+	}
+
+	if fallback {
+		s.log.Info("Setting Encryption settings (unencrypted fallback)...")
+		encryptionSettings.SetOutEncPolicy(byte(libtorrent.PeSettingsDisabled))
+		encryptionSettings.SetInEncPolicy(byte(libtorrent.PeSettingsEnabled))
+		encryptionSettings.SetAllowedEncLevel(byte(libtorrent.PeSettingsBoth))
+	} else {
+		s.log.Info("Setting Encryption settings...")
+		encryptionSettings.SetOutEncPolicy(policy)
+		encryptionSettings.SetInEncPolicy(policy)
+		encryptionSettings.SetAllowedEncLevel(level)
+	}
+	encryptionSettings.SetPreferRc4(level == byte(libtorrent.PeSettingsRc4))
+	s.Session.SetPeSettings(encryptionSettings)
+}
+
+// setEncryptionFallback temporarily allows unencrypted outgoing connections,
+// for torrents that can't find any peer with encryption on.
+func (s *BTService) setEncryptionFallback(enable bool) {
+	s.bandwidthLock.Lock()
+	changed := s.encryptionFallback != enable
+	s.encryptionFallback = enable
+	s.bandwidthLock.Unlock()
+
+	if changed {
+		s.configureEncryption()
+	}
+}
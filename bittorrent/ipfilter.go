@@ -0,0 +1,190 @@
+package bittorrent
+
+import (
+	"io"
+	"os"
+	"net"
+	"fmt"
+	"time"
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"compress/gzip"
+
+	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/util"
+)
+
+const (
+	ipFilterDefaultRefresh = 24 * time.Hour
+	ipFilterFetchTimeout   = 2 * time.Minute
+)
+
+type ipRange struct {
+	start net.IP
+	end   net.IP
+}
+
+// parseIPFilterLine understands P2P ("name:1.2.3.4-1.2.3.255"), DAT
+// ("1.2.3.4 - 1.2.3.255 , 000 , name"), CIDR and single address lines.
+func parseIPFilterLine(line string) (*ipRange, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return nil, nil
+	}
+
+	// DAT: ranges with an access level below 128 are blocked
+	if fields := strings.Split(line, ","); len(fields) >= 2 {
+		if level, err := strconv.Atoi(strings.TrimSpace(fields[1])); err == nil {
+			if level >= 128 {
+				return nil, nil
+			}
+			return parseIPRange(fields[0])
+		}
+	}
+
+	// P2P: the description may itself contain colons
+	if i := strings.LastIndex(line, ":"); i >= 0 && strings.Contains(line[i:], ".") && strings.Contains(line[i:], "-") {
+		return parseIPRange(line[i + 1:])
+	}
+
+	return parseIPRange(line)
+}
+
+func parseIPRange(value string) (*ipRange, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		start := ipNet.IP
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^ipNet.Mask[i]
+		}
+		return &ipRange{start: start, end: end}, nil
+	}
+
+	bounds := strings.SplitN(value, "-", 2)
+	start := parseIP(bounds[0])
+	end := start
+	if len(bounds) == 2 {
+		end = parseIP(bounds[1])
+	}
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("Invalid IP range %q", value)
+	}
+	return &ipRange{start: start, end: end}, nil
+}
+
+// parseIP also accepts the zero padded addresses of DAT files, e.g.
+// 001.002.003.004
+func parseIP(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ":") {
+		return net.ParseIP(value)
+	}
+	octets := strings.Split(value, ".")
+	for i, octet := range octets {
+		if trimmed := strings.TrimLeft(octet, "0"); trimmed != "" {
+			octets[i] = trimmed
+		} else {
+			octets[i] = "0"
+		}
+	}
+	return net.ParseIP(strings.Join(octets, "."))
+}
+
+func (s *BTService) readIPFilter(source string) ([]*ipRange, error) {
+	var reader io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := util.NewHTTPClient(ipFilterFetchTimeout).Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("Bad status %d fetching %s", resp.StatusCode, source)
+		}
+		reader = resp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	buffered := bufio.NewReader(reader)
+	if magic, err := buffered.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		buffered = bufio.NewReader(gzipReader)
+	}
+
+	ranges := make([]*ipRange, 0)
+	invalid := 0
+	scanner := bufio.NewScanner(buffered)
+	for scanner.Scan() {
+		r, err := parseIPFilterLine(scanner.Text())
+		if err != nil {
+			invalid++
+			continue
+		}
+		if r != nil {
+			ranges = append(ranges, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if invalid > 0 {
+		s.log.Warningf("Skipped %d invalid lines in %s", invalid, source)
+	}
+	return ranges, nil
+}
+
+func (s *BTService) loadIPFilter() {
+	filter := libtorrent.NewIpFilter()
+	defer libtorrent.DeleteIpFilter(filter)
+
+	source := s.getConfig().IPFilterSource
+	if source != "" {
+		ranges, err := s.readIPFilter(source)
+		if err != nil {
+			s.log.Errorf("Unable to load IP filter from %s: %s", source, err)
+			return
+		}
+		for _, r := range ranges {
+			filter.AddRule(libtorrent.AddressFromString(r.start.String()), libtorrent.AddressFromString(r.end.String()), int(libtorrent.IpFilterBlocked))
+		}
+		s.log.Infof("Loaded %d IP filter rules from %s", len(ranges), source)
+	}
+
+	s.Session.SetIpFilter(filter)
+}
+
+func (s *BTService) ipFilterLoop() {
+	s.loadIPFilter()
+
+	for {
+		refresh := s.getConfig().IPFilterRefresh
+		if refresh <= 0 {
+			refresh = ipFilterDefaultRefresh
+		}
+		select {
+		case <-time.After(refresh):
+			if s.getConfig().IPFilterSource != "" {
+				s.loadIPFilter()
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package bittorrent
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"sync"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+
+	"github.com/op/go-logging"
+	"github.com/zeebo/bencode"
+	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/util"
+)
+
+const (
+	metadataFetchTimeout = 5 * time.Second
+	maxResolved          = 200
+)
+
+// Metadata cache URL templates, %s being the upper-cased info-hash.
+var DefaultMetadataCaches = []string{
+	"http://itorrents.org/torrent/%s.torrent",
+}
+
+var (
+	metadataLog = logging.MustGetLogger("metadata")
+
+	resolved      = make(map[string][]byte)
+	resolvedOrder = make([]string, 0, maxResolved)
+	resolvedLock  sync.Mutex
+)
+
+func metadataCaches() []string {
+	if caches := config.Get().MetadataCaches; len(caches) > 0 {
+		return caches
+	}
+	return DefaultMetadataCaches
+}
+
+func metadataCacheURLs(infoHash string) []string {
+	urls := make([]string, 0)
+	for _, cache := range metadataCaches() {
+		urls = append(urls, fmt.Sprintf(cache, strings.ToUpper(infoHash)))
+	}
+	return urls
+}
+
+func torrentFilePath(torrentsPath string, infoHash string) string {
+	return filepath.Join(torrentsPath, fmt.Sprintf("%s.torrent", strings.ToLower(infoHash)))
+}
+
+// metadataInfo returns the raw info dictionary of a bencoded .torrent file.
+func metadataInfo(data []byte) (bencode.RawMessage, error) {
+	var torrentFile struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.DecodeBytes(data, &torrentFile); err != nil {
+		return nil, err
+	}
+	if len(torrentFile.Info) == 0 {
+		return nil, errors.New("No info dictionary in torrent file")
+	}
+	return torrentFile.Info, nil
+}
+
+// metadataInfoHash returns the info-hash of a bencoded .torrent file, computed
+// over the raw info dictionary so that unknown keys don't alter it.
+func metadataInfoHash(data []byte) (string, error) {
+	info, err := metadataInfo(data)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha1.New()
+	hasher.Write(info)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func saveTorrentFile(torrentsPath string, infoHash string, data []byte) error {
+	if torrentsPath == "" || infoHash == "" {
+		return errors.New("Missing torrents path or info-hash")
+	}
+	path := torrentFilePath(torrentsPath, infoHash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// fetchMetadataFrom returns the .torrent of cacheURL, nil if it's missing or
+// its info-hash doesn't match.
+func fetchMetadataFrom(cacheURL string, infoHash string) []byte {
+	resp, err := util.NewInsecureHTTPClient(metadataFetchTimeout).Get(cacheURL)
+	if err != nil {
+		metadataLog.Warningf("Unable to fetch metadata from %s: %s", cacheURL, err)
+		return nil
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		metadataLog.Warningf("Unable to fetch metadata from %s: bad response", cacheURL)
+		return nil
+	}
+	if hash, err := metadataInfoHash(data); err != nil || hash != infoHash {
+		metadataLog.Warningf("Invalid metadata from %s", cacheURL)
+		return nil
+	}
+	return data
+}
+
+// fetchMetadata asks every metadata cache at once and returns the first
+// .torrent whose info-hash matches.
+func fetchMetadata(infoHash string) ([]byte, error) {
+	infoHash = strings.ToLower(infoHash)
+	cacheURLs := metadataCacheURLs(infoHash)
+	results := make(chan []byte, len(cacheURLs))
+	for _, cacheURL := range cacheURLs {
+		go func(cacheURL string) {
+			results <- fetchMetadataFrom(cacheURL, infoHash)
+		}(cacheURL)
+	}
+	for i := 0; i < len(cacheURLs); i++ {
+		if data := <-results; data != nil {
+			metadataLog.Infof("Fetched metadata for %s", infoHash)
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("No metadata cache had %s", infoHash)
+}
+
+// loadTorrentInfo returns the cached metadata for infoHash, if any.
+func loadTorrentInfo(torrentsPath string, infoHash string) libtorrent.TorrentInfo {
+	path := torrentFilePath(torrentsPath, infoHash)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if hash, err := metadataInfoHash(data); err != nil || hash != strings.ToLower(infoHash) {
+		metadataLog.Warningf("Removing invalid cached metadata %s", path)
+		os.Remove(path)
+		return nil
+	}
+	return libtorrent.NewTorrentInfo(path)
+}
+
+func (s *BTService) saveMetadataConsumer() {
+	alerts, alertsDone := s.Alerts()
+	defer close(alertsDone)
+
+	for {
+		select {
+		case alert, ok := <-alerts:
+			if !ok { // was the alerts channel closed?
+				return
+			}
+			switch alert.Type() {
+			case libtorrent.MetadataReceivedAlertAlertType:
+				metadataAlert := libtorrent.SwigcptrMetadataReceivedAlert(alert.Swigcptr())
+				torrentHandle := metadataAlert.GetHandle()
+				if torrentHandle.IsValid() == false {
+					continue
+				}
+				infoHash := hex.EncodeToString([]byte(torrentHandle.InfoHash().ToString()))
+				if _, err := os.Stat(torrentFilePath(s.getConfig().TorrentsPath, infoHash)); err == nil {
+					continue
+				}
+
+				torrentInfo := torrentHandle.TorrentFile()
+				torrentFile := libtorrent.NewCreateTorrent(torrentInfo)
+				data := []byte(libtorrent.Bencode(torrentFile.Generate()))
+				libtorrent.DeleteCreateTorrent(torrentFile)
+
+				s.log.Infof("Saving metadata for %s to %s.torrent", torrentInfo.Name(), infoHash)
+				if err := saveTorrentFile(s.getConfig().TorrentsPath, infoHash, data); err != nil {
+					s.log.Errorf("Unable to save metadata for %s: %s", infoHash, err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// rememberResolved keeps a .torrent downloaded by Torrent.Resolve in memory,
+// so that it's only written to TorrentsPath if the torrent is then added.
+// Provider searches resolve many more torrents than are ever played.
+func rememberResolved(data []byte) (string, error) {
+	infoHash, err := metadataInfoHash(data)
+	if err != nil {
+		return "", err
+	}
+	resolvedLock.Lock()
+	defer resolvedLock.Unlock()
+	if _, ok := resolved[infoHash]; ok {
+		return infoHash, nil
+	}
+	if len(resolvedOrder) >= maxResolved {
+		delete(resolved, resolvedOrder[0])
+		resolvedOrder = resolvedOrder[1:]
+	}
+	resolved[infoHash] = data
+	resolvedOrder = append(resolvedOrder, infoHash)
+	return infoHash, nil
+}
+
+// saveResolved writes the .torrent resolved for infoHash, if any, to
+// torrentsPath.
+func saveResolved(torrentsPath string, infoHash string) {
+	infoHash = strings.ToLower(infoHash)
+	resolvedLock.Lock()
+	data, ok := resolved[infoHash]
+	resolvedLock.Unlock()
+	if ok == false {
+		return
+	}
+	if err := saveTorrentFile(torrentsPath, infoHash, data); err != nil {
+		metadataLog.Warningf("Unable to save metadata for %s: %s", infoHash, err)
+	}
+}
+
+// ImportTorrentData validates and caches a raw .torrent, returning its
+// info-hash.
+func (s *BTService) ImportTorrentData(data []byte) (string, error) {
+	infoHash, err := metadataInfoHash(data)
+	if err != nil {
+		return "", err
+	}
+	return infoHash, saveTorrentFile(s.getConfig().TorrentsPath, infoHash, data)
+}
+
+// ExportTorrentData returns the .torrent metadata for infoHash, either from
+// the cache or generated from the active torrent.
+func (s *BTService) ExportTorrentData(infoHash string) ([]byte, error) {
+	infoHash, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := ioutil.ReadFile(torrentFilePath(s.getConfig().TorrentsPath, infoHash)); err == nil {
+		return data, nil
+	}
+
+	torrentsVector := s.Session.GetTorrents()
+	torrentsVectorSize := int(torrentsVector.Size())
+	for i := 0; i < torrentsVectorSize; i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.IsValid() == false {
+			continue
+		}
+		if hex.EncodeToString([]byte(torrentHandle.InfoHash().ToString())) != infoHash {
+			continue
+		}
+		status := torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
+		if status.GetHasMetadata() == false {
+			return nil, fmt.Errorf("No metadata yet for %s", infoHash)
+		}
+		torrentFile := libtorrent.NewCreateTorrent(torrentHandle.TorrentFile())
+		defer libtorrent.DeleteCreateTorrent(torrentFile)
+		data := []byte(libtorrent.Bencode(torrentFile.Generate()))
+		saveTorrentFile(s.getConfig().TorrentsPath, infoHash, data)
+		return data, nil
+	}
+	return nil, fmt.Errorf("Unable to find torrent %s", infoHash)
+}
@@ -0,0 +1,171 @@
+package bittorrent
+
+import (
+	"net"
+	"fmt"
+	"time"
+
+	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+const (
+	interfaceWatchInterval = 5 * time.Second
+)
+
+// bindAddress resolves the configured interface name or address to the
+// address to bind on, and fails if it isn't currently available.
+func bindAddress(bindInterface string) (string, error) {
+	if ip := net.ParseIP(bindInterface); ip != nil {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return "", err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ip.String(), nil
+			}
+		}
+		return "", fmt.Errorf("No interface has address %s", bindInterface)
+	}
+
+	iface, err := net.InterfaceByName(bindInterface)
+	if err != nil {
+		return "", err
+	}
+	if iface.Flags & net.FlagUp == 0 {
+		return "", fmt.Errorf("Interface %s is down", bindInterface)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	var fallback string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if fallback == "" && ipNet.IP.IsLinkLocalUnicast() == false {
+			fallback = ipNet.IP.String()
+		}
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("Interface %s has no address", bindInterface)
+}
+
+func (s *BTService) Listen() {
+	s.listenLock.Lock()
+	defer s.listenLock.Unlock()
+
+	errCode := libtorrent.NewErrorCode()
+	defer libtorrent.DeleteErrorCode(errCode)
+	ports := libtorrent.NewStdPairIntInt(s.getConfig().LowerListenPort, s.getConfig().UpperListenPort)
+	defer libtorrent.DeleteStdPairIntInt(ports)
+
+	listenUnbound := func() {
+		if s.boundAddress != "" {
+			settings := s.Session.Settings()
+			settings.SetOutgoingInterfaces("")
+			s.Session.SetSettings(settings)
+			s.boundAddress = ""
+		}
+		s.Session.ListenOn(ports, errCode)
+	}
+
+	if s.getConfig().BindInterface == "" {
+		listenUnbound()
+		return
+	}
+
+	address, err := bindAddress(s.getConfig().BindInterface)
+	if err != nil {
+		s.log.Errorf("Unable to bind to %s: %s", s.getConfig().BindInterface, err)
+		if s.getConfig().KillSwitch {
+			s.tripKillSwitch()
+			return
+		}
+		// Binding is retried by watchBindInterface once the interface is up
+		s.log.Warningf("Listening on all interfaces until %s is available", s.getConfig().BindInterface)
+		listenUnbound()
+		return
+	}
+
+	s.log.Infof("Binding to %s (%s)", s.getConfig().BindInterface, address)
+	settings := s.Session.Settings()
+	settings.SetOutgoingInterfaces(address)
+	s.Session.SetSettings(settings)
+	s.Session.ListenOn(ports, errCode, address)
+	s.boundAddress = address
+}
+
+func (s *BTService) currentBoundAddress() string {
+	s.listenLock.Lock()
+	defer s.listenLock.Unlock()
+	return s.boundAddress
+}
+
+func (s *BTService) tripKillSwitch() {
+	s.bandwidthLock.Lock()
+	defer s.bandwidthLock.Unlock()
+	if s.killSwitchTripped {
+		return
+	}
+	s.log.Warningf("Interface %s is gone, pausing all torrents", s.getConfig().BindInterface)
+	s.killSwitchTripped = true
+	s.Session.Pause()
+	xbmc.Notify("Quasar", "LOCALIZE[30305]", config.AddonIcon())
+}
+
+func (s *BTService) resetKillSwitch() {
+	s.bandwidthLock.Lock()
+	tripped := s.killSwitchTripped
+	s.killSwitchTripped = false
+	s.bandwidthLock.Unlock()
+	if tripped == false {
+		return
+	}
+	s.log.Infof("Interface %s is back, resuming", s.getConfig().BindInterface)
+	s.Listen()
+	s.Session.Resume()
+}
+
+// watchBindInterface pauses the session when the bound interface (e.g. a VPN
+// tunnel) disappears, so no traffic leaks through another route.
+func (s *BTService) watchBindInterface() {
+	watchTicker := time.NewTicker(interfaceWatchInterval)
+	defer watchTicker.Stop()
+
+	for {
+		select {
+		case <-watchTicker.C:
+			if s.getConfig().BindInterface == "" {
+				s.resetKillSwitch()
+				continue
+			}
+			address, err := bindAddress(s.getConfig().BindInterface)
+			if err != nil {
+				if s.getConfig().KillSwitch {
+					s.tripKillSwitch()
+				} else if s.currentBoundAddress() != "" {
+					// Don't stay bound to a dead address
+					s.Listen()
+				}
+				continue
+			}
+			s.resetKillSwitch()
+			if address != s.currentBoundAddress() {
+				s.log.Infof("Address of %s changed to %s", s.getConfig().BindInterface, address)
+				s.Listen()
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
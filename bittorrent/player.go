@@ -8,6 +8,7 @@ import (
 	"time"
 	"errors"
 	"strings"
+	"encoding/hex"
 	"io/ioutil"
 	"path/filepath"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/broadcast"
 	"github.com/scakemyer/quasar/diskusage"
+	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
@@ -47,9 +49,11 @@ type BTPlayer struct {
 	resume                   int
 	fastResumeFile           string
 	notEnoughSpace           bool
+	encryptionFallback       bool
 	diskStatus               *diskusage.DiskStatus
 	closing                  chan interface{}
 	bufferEvents             *broadcast.Broadcaster
+	scrobble                 *trakt.Scrobble
 }
 
 func NewBTPlayer(bts *BTService, uri string, fileIndex int, resume int, infoHash string) *BTPlayer {
@@ -71,16 +75,22 @@ func NewBTPlayer(bts *BTService, uri string, fileIndex int, resume int, infoHash
 	return btp
 }
 
+// SetScrobble makes the player report its playback to Trakt as the given
+// item.
+func (btp *BTPlayer) SetScrobble(scrobble *trakt.Scrobble) {
+	btp.scrobble = scrobble
+}
+
 func (btp *BTPlayer) addTorrent() error {
 	btp.log.Info("Adding torrent")
 
-	if btp.bts.config.DownloadPath == "." {
+	if btp.bts.getConfig().DownloadPath == "." {
 		xbmc.Notify("Quasar", "LOCALIZE[30113]", config.AddonIcon())
 		return fmt.Errorf("Download path empty")
 	}
 
-	if status, err := diskusage.DiskUsage(btp.bts.config.DownloadPath); err != nil {
-		btp.bts.log.Warningf("Unable to retrieve the free space for %s, continuing anyway...", btp.bts.config.DownloadPath)
+	if status, err := diskusage.DiskUsage(btp.bts.getConfig().DownloadPath); err != nil {
+		btp.bts.log.Warningf("Unable to retrieve the free space for %s, continuing anyway...", btp.bts.getConfig().DownloadPath)
 	} else {
 		btp.diskStatus = status
 	}
@@ -90,11 +100,22 @@ func (btp *BTPlayer) addTorrent() error {
 
 	torrentParams.SetUrl(btp.uri)
 
-	btp.log.Infof("Setting save path to %s", btp.bts.config.DownloadPath)
-	torrentParams.SetSavePath(btp.bts.config.DownloadPath)
+	raceMetadata := false
+	if btp.infoHash != "" {
+		saveResolved(btp.bts.getConfig().TorrentsPath, btp.infoHash)
+		if torrentInfo := loadTorrentInfo(btp.bts.getConfig().TorrentsPath, btp.infoHash); torrentInfo != nil {
+			btp.log.Infof("Using cached metadata from %s.torrent", btp.infoHash)
+			torrentParams.SetTorrentInfo(torrentInfo)
+		} else {
+			raceMetadata = true
+		}
+	}
+
+	btp.log.Infof("Setting save path to %s", btp.bts.getConfig().DownloadPath)
+	torrentParams.SetSavePath(btp.bts.getConfig().DownloadPath)
 
 	btp.log.Infof("Checking for fast resume data in %s.fastresume", btp.infoHash)
-	fastResumeFile := filepath.Join(btp.bts.config.TorrentsPath, fmt.Sprintf("%s.fastresume", btp.infoHash))
+	fastResumeFile := filepath.Join(btp.bts.getConfig().TorrentsPath, fmt.Sprintf("%s.fastresume", btp.infoHash))
 	if _, err := os.Stat(fastResumeFile); err == nil {
 		btp.log.Info("Found fast resume data...")
 		btp.fastResumeFile = fastResumeFile
@@ -116,6 +137,10 @@ func (btp *BTPlayer) addTorrent() error {
 		return fmt.Errorf("Unable to add torrent with URI %s", btp.uri)
 	}
 
+	if raceMetadata {
+		go btp.raceMetadata()
+	}
+
 	btp.log.Info("Enabling sequential download")
 	btp.torrentHandle.SetSequentialDownload(true)
 
@@ -131,6 +156,38 @@ func (btp *BTPlayer) addTorrent() error {
 	return nil
 }
 
+// raceMetadata fetches the metadata from the caches while libtorrent gets it
+// from peers, handing it over if the caches are faster.
+func (btp *BTPlayer) raceMetadata() {
+	data, err := fetchMetadata(btp.infoHash)
+	if err != nil {
+		btp.log.Info(err)
+		return
+	}
+	if err := saveTorrentFile(btp.bts.getConfig().TorrentsPath, btp.infoHash, data); err != nil {
+		btp.log.Warningf("Unable to save metadata for %s: %s", btp.infoHash, err)
+	}
+	info, err := metadataInfo(data)
+	if err != nil {
+		return
+	}
+
+	select {
+	case <-btp.closing:
+		return
+	default:
+	}
+	if btp.torrentHandle.IsValid() == false {
+		return
+	}
+	status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
+	if status.GetHasMetadata() == true {
+		return
+	}
+	btp.log.Infof("Using metadata from cache for %s", btp.infoHash)
+	btp.torrentHandle.SetMetadata(string(info), len(info))
+}
+
 func (btp *BTPlayer) resumeTorrent(torrentIndex int) error {
 	torrentsVector := btp.bts.Session.GetTorrents()
 	btp.torrentHandle = torrentsVector.Get(torrentIndex)
@@ -165,6 +222,8 @@ func (btp *BTPlayer) Buffer() error {
 		}
 	}
 
+	btp.bts.SetStreaming(btp.handleInfoHash(), true)
+
 	buffered, done := btp.bufferEvents.Listen()
 	defer close(done)
 
@@ -190,14 +249,14 @@ func (btp *BTPlayer) CheckAvailableSpace() bool {
 		status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
 		sizeLeft := btp.torrentInfo.TotalSize() - status.GetTotalDone()
 
-		btp.log.Infof("Checking for sufficient space on %s...", btp.bts.config.DownloadPath)
+		btp.log.Infof("Checking for sufficient space on %s...", btp.bts.getConfig().DownloadPath)
 		btp.log.Infof("Total size of download: %d", btp.torrentInfo.TotalSize())
 		btp.log.Infof("All time download: %d", status.GetAllTimeDownload())
 		btp.log.Infof("Size total done: %d", status.GetTotalDone())
 		btp.log.Infof("Size left: %d", sizeLeft)
 
 		if btp.diskStatus.Free < sizeLeft {
-			btp.log.Errorf("Unsufficient free space on %s. Has %d, needs %d.", btp.bts.config.DownloadPath, btp.diskStatus.Free, sizeLeft)
+			btp.log.Errorf("Unsufficient free space on %s. Has %d, needs %d.", btp.bts.getConfig().DownloadPath, btp.diskStatus.Free, sizeLeft)
 			xbmc.Notify("Quasar", "LOCALIZE[30207]", config.AddonIcon())
 			btp.bufferEvents.Broadcast(errors.New("Not enough space on download destination."))
 			btp.notEnoughSpace = true
@@ -232,8 +291,8 @@ func (btp *BTPlayer) onMetadataReceived() {
 	startPiece, endPiece, _ := btp.getFilePiecesAndOffset(btp.chosenFile)
 
 	startLength := float64(endPiece-startPiece) * float64(pieceLength) * startBufferPercent
-	if startLength < float64(btp.bts.config.BufferSize) {
-		startLength = float64(btp.bts.config.BufferSize)
+	if startLength < float64(btp.bts.getConfig().BufferSize) {
+		startLength = float64(btp.bts.getConfig().BufferSize)
 	}
 	startBufferPieces := int(math.Ceil(startLength / pieceLength))
 
@@ -359,9 +418,18 @@ func (btp *BTPlayer) onStateChanged(stateAlert libtorrent.StateChangedAlert) {
 	}
 }
 
+func (btp *BTPlayer) handleInfoHash() string {
+	return hex.EncodeToString([]byte(btp.torrentHandle.InfoHash().ToString()))
+}
+
 func (btp *BTPlayer) Close() {
 	close(btp.closing)
 
+	btp.bts.SetStreaming(btp.handleInfoHash(), false)
+	if btp.encryptionFallback {
+		btp.bts.setEncryptionFallback(false)
+	}
+
 	if btp.backgroundHandling == false || btp.notEnoughSpace {
 		if btp.torrentInfo != nil && btp.torrentInfo.Swigcptr() != 0 {
 			libtorrent.DeleteTorrentInfo(btp.torrentInfo)
@@ -447,6 +515,7 @@ func (btp *BTPlayer) bufferDialog() {
 	defer halfSecond.Stop()
 	oneSecond := time.NewTicker(1 * time.Second)
 	defer oneSecond.Stop()
+	bufferStart := time.Now()
 
 	for {
 		select {
@@ -459,6 +528,14 @@ func (btp *BTPlayer) bufferDialog() {
 		case <-oneSecond.C:
 			status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
 
+			if btp.encryptionFallback == false && btp.bts.getConfig().EncryptionFallback &&
+				btp.bts.getConfig().EncryptionPolicy != EncryptionDisabled &&
+				status.GetNumPeers() == 0 && time.Since(bufferStart) > encryptionFallbackDelay {
+				btp.log.Info("No peers found, retrying without encryption")
+				btp.encryptionFallback = true
+				btp.bts.setEncryptionFallback(true)
+			}
+
 			// Handle "Checking" state for resumed downloads
 			if int(status.GetState()) == 1 {
 				progress := float64(status.GetProgress())
@@ -489,24 +566,13 @@ func (btp *BTPlayer) bufferDialog() {
 }
 
 func (btp *BTPlayer) setRateLimiting(enable bool) {
-	if btp.bts.config.LimitAfterBuffering == true {
-		settings := btp.bts.Session.Settings()
+	if btp.bts.getConfig().LimitAfterBuffering == true {
 		if enable == true {
-			if btp.bts.config.MaxDownloadRate > 0 {
-				btp.log.Infof("Buffer filled, rate limiting download to %dkb/s", btp.bts.config.MaxDownloadRate/1024)
-				settings.SetDownloadRateLimit(btp.bts.config.MaxDownloadRate)
-			}
-			if btp.bts.config.MaxUploadRate > 0 {
-				// If we have an upload rate, use the nicer bittyrant choker
-				btp.log.Infof("Buffer filled, rate limiting upload to %dkb/s", btp.bts.config.MaxUploadRate/1024)
-				settings.SetUploadRateLimit(btp.bts.config.MaxUploadRate)
-			}
+			btp.log.Info("Buffer filled, applying rate limiting")
 		} else {
 			btp.log.Info("Resetting rate limiting")
-			settings.SetDownloadRateLimit(0)
-			settings.SetUploadRateLimit(0)
 		}
-		btp.bts.Session.SetSettings(settings)
+		btp.bts.SetBuffered(enable)
 	}
 }
 
@@ -546,6 +612,15 @@ playbackWaitLoop:
 	btp.log.Info("Playback loop")
 	overlayStatusActive := false
 
+	scrobbles := btp.scrobbler()
+	defer close(scrobbles)
+	playProgress := 0.0
+	if btp.scrobble != nil {
+		playProgress = xbmc.PlayerGetPercentage()
+	}
+	paused := false
+	scrobbles <- scrobbleEvent{"start", playProgress}
+
 playbackLoop:
 	for {
 		if xbmc.PlayerIsPlaying() == false {
@@ -553,7 +628,20 @@ playbackLoop:
 		}
 		select {
 		case <-oneSecond.C:
-			if xbmc.PlayerIsPaused() && config.Get().EnableOverlayStatus == true {
+			isPaused := xbmc.PlayerIsPaused()
+			if isPaused == false && btp.scrobble != nil {
+				playProgress = xbmc.PlayerGetPercentage()
+			}
+			if isPaused != paused {
+				paused = isPaused
+				if paused {
+					scrobbles <- scrobbleEvent{"pause", playProgress}
+				} else {
+					scrobbles <- scrobbleEvent{"start", playProgress}
+				}
+			}
+
+			if isPaused && config.Get().EnableOverlayStatus == true {
 				status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
 				progress := float64(status.GetProgress())
 				line1, line2, line3 := btp.statusStrings(progress, status)
@@ -570,8 +658,32 @@ playbackLoop:
 			}
 		}
 	}
+	scrobbles <- scrobbleEvent{"stop", playProgress}
 	if overlayStatusActive == true {
 		btp.overlayStatus.Close()
 	}
 	btp.setRateLimiting(false)
 }
+
+type scrobbleEvent struct {
+	action   string
+	progress float64
+}
+
+// scrobbler sends the playback events to Trakt in order, without holding
+// up the playback loop.
+func (btp *BTPlayer) scrobbler() chan<- scrobbleEvent {
+	events := make(chan scrobbleEvent, 8)
+	go func() {
+		for event := range events {
+			if btp.scrobble == nil || trakt.Authorized() == false {
+				continue
+			}
+			btp.log.Infof("Scrobbling %s at %.1f%%", event.action, event.progress)
+			if err := btp.scrobble.Send(event.action, event.progress); err != nil {
+				btp.log.Warningf("Unable to scrobble %s: %s", event.action, err)
+			}
+		}
+	}()
+	return events
+}
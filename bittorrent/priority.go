@@ -0,0 +1,102 @@
+package bittorrent
+
+import (
+	"time"
+	"encoding/hex"
+
+	"github.com/scakemyer/libtorrent-go"
+)
+
+// Bandwidth priorities, libtorrent hands out rate quotas proportionally to
+// these (0-255).
+const (
+	PrioritySeeding   = 0
+	PriorityQueued    = 128
+	PriorityStreaming = 255
+)
+
+const (
+	prioritiesInterval = 10 * time.Second
+)
+
+// SetStreaming marks the torrent with infoHash as being played, giving it
+// bandwidth priority over everything else in the session.
+func (s *BTService) SetStreaming(infoHash string, streaming bool) {
+	s.streamingLock.Lock()
+	if streaming {
+		s.streaming[infoHash] = true
+	} else {
+		delete(s.streaming, infoHash)
+	}
+	s.streamingLock.Unlock()
+
+	s.applyPriorities()
+}
+
+func (s *BTService) isStreaming(infoHash string) bool {
+	s.streamingLock.RLock()
+	defer s.streamingLock.RUnlock()
+	return s.streaming[infoHash]
+}
+
+func (s *BTService) hasStreaming() bool {
+	s.streamingLock.RLock()
+	defer s.streamingLock.RUnlock()
+	return len(s.streaming) > 0
+}
+
+// applyPriorities sets the priority class and per-torrent rate limits of
+// every torrent: streaming > queued > seeding. While something is playing,
+// the other torrents are throttled to the background rate limits.
+func (s *BTService) applyPriorities() {
+	throttle := s.hasStreaming()
+
+	torrentsVector := s.Session.GetTorrents()
+	torrentsVectorSize := int(torrentsVector.Size())
+	for i := 0; i < torrentsVectorSize; i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.IsValid() == false {
+			continue
+		}
+
+		infoHash := hex.EncodeToString([]byte(torrentHandle.InfoHash().ToString()))
+		if s.isStreaming(infoHash) {
+			torrentHandle.SetPriority(PriorityStreaming)
+			torrentHandle.SetDownloadLimit(0)
+			torrentHandle.SetUploadLimit(0)
+			torrentHandle.QueuePositionTop()
+			continue
+		}
+
+		status := torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
+		if status.GetIsSeeding() || status.GetIsFinished() {
+			torrentHandle.SetPriority(PrioritySeeding)
+		} else {
+			torrentHandle.SetPriority(PriorityQueued)
+		}
+
+		if throttle {
+			torrentHandle.SetDownloadLimit(s.getConfig().MaxBackgroundDownloadRate)
+			torrentHandle.SetUploadLimit(s.getConfig().MaxBackgroundUploadRate)
+		} else {
+			torrentHandle.SetDownloadLimit(0)
+			torrentHandle.SetUploadLimit(0)
+		}
+	}
+}
+
+// prioritiesLoop keeps the priority classes up to date as torrents are
+// added and finish downloading.
+func (s *BTService) prioritiesLoop() {
+	prioritiesTicker := time.NewTicker(prioritiesInterval)
+	defer prioritiesTicker.Stop()
+
+	for {
+		select {
+		case <-prioritiesTicker.C:
+			s.applyPriorities()
+		case <-s.closing:
+			return
+		}
+	}
+}
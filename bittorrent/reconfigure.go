@@ -0,0 +1,180 @@
+package bittorrent
+
+import (
+	"os"
+	"fmt"
+	"reflect"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const (
+	changeRates = 1 << iota
+	changeConnections
+	changeProxy
+	changeListenPorts
+	changePaths
+	changeIPFilter
+	changeEncryption
+	changeOther
+)
+
+// diffConfiguration classifies the fields that differ between two
+// configurations.
+func diffConfiguration(current *BTConfiguration, updated *BTConfiguration) int {
+	changes := 0
+	if current.MaxDownloadRate != updated.MaxDownloadRate ||
+		current.MaxUploadRate != updated.MaxUploadRate ||
+		current.MaxBackgroundDownloadRate != updated.MaxBackgroundDownloadRate ||
+		current.MaxBackgroundUploadRate != updated.MaxBackgroundUploadRate ||
+		current.AltDownloadRate != updated.AltDownloadRate ||
+		current.AltUploadRate != updated.AltUploadRate ||
+		current.LimitAfterBuffering != updated.LimitAfterBuffering ||
+		reflect.DeepEqual(current.BandwidthSchedule, updated.BandwidthSchedule) == false {
+		changes |= changeRates
+	}
+	if current.ConnectionsLimit != updated.ConnectionsLimit {
+		changes |= changeConnections
+	}
+	if reflect.DeepEqual(current.Proxy, updated.Proxy) == false {
+		changes |= changeProxy
+	}
+	if current.LowerListenPort != updated.LowerListenPort ||
+		current.UpperListenPort != updated.UpperListenPort ||
+		current.BindInterface != updated.BindInterface ||
+		current.KillSwitch != updated.KillSwitch {
+		changes |= changeListenPorts
+	}
+	if current.DownloadPath != updated.DownloadPath || current.TorrentsPath != updated.TorrentsPath {
+		changes |= changePaths
+	}
+	if current.EncryptionPolicy != updated.EncryptionPolicy || current.EncryptionLevel != updated.EncryptionLevel {
+		changes |= changeEncryption
+	}
+	if current.IPFilterSource != updated.IPFilterSource {
+		changes |= changeIPFilter
+	}
+	if current.BackgroundHandling != updated.BackgroundHandling || current.SessionSave != updated.SessionSave {
+		changes |= changeOther
+	}
+	return changes
+}
+
+func (s *BTService) activeTorrents() int {
+	active := 0
+	torrentsVector := s.Session.GetTorrents()
+	torrentsVectorSize := int(torrentsVector.Size())
+	for i := 0; i < torrentsVectorSize; i++ {
+		if torrentsVector.Get(i).IsValid() {
+			active++
+		}
+	}
+	return active
+}
+
+// moveTorrentsFolder moves the .torrent and .fastresume files over to a new
+// Torrents folder. Files that can't be moved are left behind and reported.
+func (s *BTService) moveTorrentsFolder(from string, to string) error {
+	if _, err := os.Stat(to); os.IsNotExist(err) {
+		if err := os.Mkdir(to, 0755); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	files, _ := filepath.Glob(filepath.Join(from, "*.torrent"))
+	resumeFiles, _ := filepath.Glob(filepath.Join(from, "*.fastresume"))
+	for _, file := range append(files, resumeFiles...) {
+		moved := filepath.Join(to, filepath.Base(file))
+		if os.Rename(file, moved) == nil {
+			continue
+		}
+		// Different devices, copy it instead
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = writeFileAtomic(moved, data, 0644)
+		}
+		if err != nil {
+			s.log.Warningf("Unable to move %s to %s: %s", file, to, err)
+			failed++
+			continue
+		}
+		os.Remove(file)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be moved to %s", failed, to)
+	}
+	return nil
+}
+
+// ApplyConfiguration only re-applies the settings that changed, unlike
+// Reconfigure which restarts every service. Running players are left alone.
+// Changes that can't be made live are kept at their old value and reported
+// in the returned error, the rest is still applied. Paths() tells which
+// paths are in use then.
+func (s *BTService) ApplyConfiguration(config BTConfiguration) error {
+	var err error
+	current := s.getConfig()
+	changes := diffConfiguration(current, &config)
+
+	if changes & changePaths != 0 {
+		if active := s.activeTorrents(); active > 0 {
+			err = fmt.Errorf("Unable to change the download path while %d torrents are active", active)
+			s.log.Warning(err)
+			config.DownloadPath = current.DownloadPath
+			config.TorrentsPath = current.TorrentsPath
+			changes &^= changePaths
+		} else if current.TorrentsPath != config.TorrentsPath {
+			s.log.Infof("Moving Torrents folder to %s", config.TorrentsPath)
+			if moveErr := s.moveTorrentsFolder(current.TorrentsPath, config.TorrentsPath); moveErr != nil {
+				err = fmt.Errorf("Unable to move the Torrents folder: %s", moveErr)
+				s.log.Warning(err)
+				// Put back what was moved, the old folder stays in use
+				s.moveTorrentsFolder(config.TorrentsPath, current.TorrentsPath)
+				config.TorrentsPath = current.TorrentsPath
+			}
+		}
+	}
+	s.setConfig(&config)
+	if changes == 0 {
+		s.log.Info("No BT settings need to be re-applied")
+		return err
+	}
+
+	if changes & (changeRates | changeConnections) != 0 {
+		s.log.Info("Applying rate and connection limits...")
+		settings := s.Session.Settings()
+		if s.getConfig().ConnectionsLimit > 0 {
+			settings.SetConnectionsLimit(s.getConfig().ConnectionsLimit)
+		}
+		s.Session.SetSettings(settings)
+		s.applyRateLimits()
+		s.applyPriorities()
+	}
+	if changes & changeProxy != 0 {
+		s.configureProxy()
+	}
+	if changes & changeListenPorts != 0 {
+		s.log.Infof("Listening on ports %d-%d", s.getConfig().LowerListenPort, s.getConfig().UpperListenPort)
+		s.Listen()
+	}
+	if changes & changeEncryption != 0 {
+		s.configureEncryption()
+	}
+	if changes & changeIPFilter != 0 {
+		go s.loadIPFilter()
+	}
+	if changes & changePaths != 0 {
+		s.log.Infof("Download path changed to %s", s.getConfig().DownloadPath)
+	}
+	if changes & changeOther != 0 {
+		s.log.Info("Background handling and session save interval will change on next start")
+	}
+
+	return err
+}
+
+// Paths returns the download and Torrents paths the service uses.
+func (s *BTService) Paths() (string, string) {
+	current := s.getConfig()
+	return current.DownloadPath, current.TorrentsPath
+}
@@ -0,0 +1,193 @@
+package bittorrent
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"io/ioutil"
+	"encoding/hex"
+	"path/filepath"
+
+	"github.com/zeebo/bencode"
+	"github.com/scakemyer/libtorrent-go"
+)
+
+const (
+	resumeFileFormat      = "libtorrent resume file"
+	resumeDataSaveTimeout = 10 * time.Second
+)
+
+type resumeData struct {
+	FileFormat string `bencode:"file-format"`
+	InfoHash   string `bencode:"info-hash"`
+	SavePath   string `bencode:"save_path"`
+	Pieces     string `bencode:"pieces"`
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so a crash mid-write never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path) + ".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// readResumeFile loads a .fastresume file and checks it is a libtorrent
+// resume file for the info-hash its name claims.
+func readResumeFile(path string) ([]byte, *resumeData, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	resume := &resumeData{}
+	if err := bencode.DecodeBytes(data, resume); err != nil {
+		return nil, nil, err
+	}
+	if resume.FileFormat != resumeFileFormat {
+		return nil, nil, fmt.Errorf("Unknown resume file format %q", resume.FileFormat)
+	}
+	infoHash := strings.TrimSuffix(filepath.Base(path), ".fastresume")
+	if hex.EncodeToString([]byte(resume.InfoHash)) != strings.ToLower(infoHash) {
+		return nil, nil, errors.New("Resume data info-hash mismatch")
+	}
+	return data, resume, nil
+}
+
+// isOrphaned reports whether the resume data refers to a torrent whose
+// downloaded data has since been deleted. Without cached metadata we can't
+// know the torrent's name, so it is kept.
+func (resume *resumeData) isOrphaned(torrentsPath string, infoHash string) bool {
+	if resume.SavePath == "" || strings.Trim(resume.Pieces, "\x00") == "" {
+		return false
+	}
+	data, err := ioutil.ReadFile(torrentFilePath(torrentsPath, infoHash))
+	if err != nil {
+		return false
+	}
+	var torrentFile struct {
+		Info struct {
+			Name string `bencode:"name"`
+		} `bencode:"info"`
+	}
+	if err := bencode.DecodeBytes(data, &torrentFile); err != nil || torrentFile.Info.Name == "" {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(resume.SavePath, torrentFile.Info.Name))
+	return os.IsNotExist(err)
+}
+
+// saveResumeDataOnClose pauses the session, asks every dirty torrent for its
+// resume data and waits, up to resumeDataSaveTimeout, for it to be written.
+// The torrents are registered before asking, so that no answer is missed and
+// answers to the periodic saves aren't counted.
+func (s *BTService) saveResumeDataOnClose() {
+	s.Session.Pause()
+
+	torrentHandles := make([]libtorrent.TorrentHandle, 0)
+	s.resumePendingLock.Lock()
+	s.resumePending = make(map[string]bool)
+	torrentsVector := s.Session.GetTorrents()
+	torrentsVectorSize := int(torrentsVector.Size())
+	for i := 0; i < torrentsVectorSize; i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.IsValid() == false {
+			continue
+		}
+		status := torrentHandle.Status()
+		if status.GetHasMetadata() == false || status.GetNeedSaveResume() == false {
+			continue
+		}
+		s.resumePending[hex.EncodeToString([]byte(status.GetInfoHash().ToString()))] = true
+		torrentHandles = append(torrentHandles, torrentHandle)
+	}
+	outstanding := len(s.resumePending)
+	s.resumeDataSaved = make(chan interface{})
+	done := s.resumeDataSaved
+	s.resumePendingLock.Unlock()
+
+	if outstanding == 0 {
+		return
+	}
+
+	for _, torrentHandle := range torrentHandles {
+		torrentHandle.SaveResumeData(1)
+	}
+
+	s.log.Infof("Waiting for resume data of %d torrents...", outstanding)
+	select {
+	case <-done:
+	case <-time.After(resumeDataSaveTimeout):
+		s.resumePendingLock.Lock()
+		s.log.Warningf("Timed out waiting for resume data of %d torrents", len(s.resumePending))
+		s.resumePending = nil
+		s.resumePendingLock.Unlock()
+	}
+}
+
+// resumeDataDone marks the resume data of infoHash as written, for
+// saveResumeDataOnClose.
+func (s *BTService) resumeDataDone(infoHash string) {
+	s.resumePendingLock.Lock()
+	defer s.resumePendingLock.Unlock()
+
+	if s.resumePending[infoHash] == false {
+		return
+	}
+	delete(s.resumePending, infoHash)
+	if len(s.resumePending) == 0 {
+		close(s.resumeDataSaved)
+	}
+}
+
+func (s *BTService) cleanOrphanedResumeFiles() {
+	pattern := filepath.Join(s.getConfig().TorrentsPath, "*.fastresume")
+	files, _ := filepath.Glob(pattern)
+	for _, fastResumeFile := range files {
+		infoHash := strings.TrimSuffix(filepath.Base(fastResumeFile), ".fastresume")
+		_, resume, err := readResumeFile(fastResumeFile)
+		if err != nil {
+			s.log.Warningf("Removing invalid fast resume file %s: %s", fastResumeFile, err)
+			os.Remove(fastResumeFile)
+			os.Remove(torrentFilePath(s.getConfig().TorrentsPath, infoHash))
+			continue
+		}
+		if resume.isOrphaned(s.getConfig().TorrentsPath, infoHash) {
+			s.log.Infof("Removing orphaned fast resume file %s and its metadata", fastResumeFile)
+			os.Remove(fastResumeFile)
+			os.Remove(torrentFilePath(s.getConfig().TorrentsPath, infoHash))
+		}
+	}
+
+	// Leftovers from interrupted atomic writes
+	tmpFiles, _ := filepath.Glob(filepath.Join(s.getConfig().TorrentsPath, "*.fastresume.tmp*"))
+	for _, tmpFile := range tmpFiles {
+		os.Remove(tmpFile)
+	}
+}
@@ -0,0 +1,214 @@
+package bittorrent
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"strconv"
+)
+
+const (
+	AltSpeedAuto = iota
+	AltSpeedOn
+	AltSpeedOff
+)
+
+const (
+	schedulerInterval = 1 * time.Minute
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ScheduleEntry is a weekly time window during which the alternative rate
+// limits apply. Windows with To before From run past midnight.
+type ScheduleEntry struct {
+	Days [7]bool
+	From time.Duration
+	To   time.Duration
+}
+
+func (e ScheduleEntry) Matches(t time.Time) bool {
+	timeOfDay := time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute
+	day := t.Weekday()
+	if e.From <= e.To {
+		return e.Days[day] && timeOfDay >= e.From && timeOfDay < e.To
+	}
+	previousDay := (day + 6) % 7
+	return (e.Days[day] && timeOfDay >= e.From) || (e.Days[previousDay] && timeOfDay < e.To)
+}
+
+// ParseBandwidthSchedule parses entries like "mon-fri 09:00-18:00; sat,sun 10:00-12:00".
+func ParseBandwidthSchedule(schedule string) ([]ScheduleEntry, error) {
+	entries := make([]ScheduleEntry, 0)
+	for _, rule := range strings.Split(schedule, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid schedule entry %q", rule)
+		}
+		entry := ScheduleEntry{}
+		if err := parseDays(fields[0], &entry.Days); err != nil {
+			return nil, err
+		}
+		hours := strings.SplitN(fields[1], "-", 2)
+		if len(hours) != 2 {
+			return nil, fmt.Errorf("Invalid time range %q", fields[1])
+		}
+		var err error
+		if entry.From, err = parseTimeOfDay(hours[0]); err != nil {
+			return nil, err
+		}
+		if entry.To, err = parseTimeOfDay(hours[1]); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseDays(days string, result *[7]bool) error {
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return fmt.Errorf("Invalid day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return fmt.Errorf("Invalid day %q", bounds[1])
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			result[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parts := strings.SplitN(value, ":", 2)
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("Invalid time %q", value)
+	}
+	minutes := 0
+	if len(parts) == 2 {
+		if minutes, err = strconv.Atoi(parts[1]); err != nil || minutes < 0 || minutes > 59 {
+			return 0, fmt.Errorf("Invalid time %q", value)
+		}
+	}
+	// 24:00 is the end of the day, nothing goes past it
+	if hours == 24 && minutes > 0 {
+		return 0, fmt.Errorf("Invalid time %q", value)
+	}
+	return time.Duration(hours) * time.Hour + time.Duration(minutes) * time.Minute, nil
+}
+
+func (s *BTService) scheduleActive(now time.Time) bool {
+	for _, entry := range s.getConfig().BandwidthSchedule {
+		if entry.Matches(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// AltSpeedActive tells whether the alternative rate limits currently apply,
+// either from the schedule or the manual override.
+func (s *BTService) AltSpeedActive() bool {
+	s.bandwidthLock.Lock()
+	defer s.bandwidthLock.Unlock()
+	switch s.altSpeedOverride {
+	case AltSpeedOn:
+		return true
+	case AltSpeedOff:
+		return false
+	}
+	return s.scheduleActive(time.Now())
+}
+
+// SetAltSpeedOverride forces the alternative rate limits on or off, or with
+// AltSpeedAuto hands control back to the schedule.
+func (s *BTService) SetAltSpeedOverride(mode int) {
+	s.bandwidthLock.Lock()
+	s.altSpeedOverride = mode
+	s.bandwidthLock.Unlock()
+	s.applyRateLimits()
+}
+
+// SetBuffered is used with LimitAfterBuffering to only apply the rate limits
+// once the player's buffer is filled.
+func (s *BTService) SetBuffered(buffered bool) {
+	s.bandwidthLock.Lock()
+	s.buffered = buffered
+	s.bandwidthLock.Unlock()
+	s.applyRateLimits()
+}
+
+func (s *BTService) ToggleAltSpeed() bool {
+	if s.AltSpeedActive() {
+		s.SetAltSpeedOverride(AltSpeedOff)
+	} else {
+		s.SetAltSpeedOverride(AltSpeedOn)
+	}
+	return s.AltSpeedActive()
+}
+
+// currentRateLimits returns the session-wide limits of the active profile.
+func (s *BTService) currentRateLimits() (int, int) {
+	if s.AltSpeedActive() {
+		return s.getConfig().AltDownloadRate, s.getConfig().AltUploadRate
+	}
+	s.bandwidthLock.Lock()
+	buffered := s.buffered
+	s.bandwidthLock.Unlock()
+	if s.getConfig().LimitAfterBuffering == true && buffered == false {
+		return 0, 0
+	}
+	return s.getConfig().MaxDownloadRate, s.getConfig().MaxUploadRate
+}
+
+// applyRateLimits only touches the rate limit settings, so switching
+// profiles doesn't restart DHT, UPnP and friends like Reconfigure does.
+func (s *BTService) applyRateLimits() {
+	downloadRate, uploadRate := s.currentRateLimits()
+	s.log.Infof("Rate limiting download to %dkb/s and upload to %dkb/s", downloadRate / 1024, uploadRate / 1024)
+
+	settings := s.Session.Settings()
+	settings.SetDownloadRateLimit(downloadRate)
+	settings.SetUploadRateLimit(uploadRate)
+	s.Session.SetSettings(settings)
+}
+
+func (s *BTService) bandwidthScheduler() {
+	schedulerTicker := time.NewTicker(schedulerInterval)
+	defer schedulerTicker.Stop()
+
+	altSpeed := s.AltSpeedActive()
+	for {
+		select {
+		case <-schedulerTicker.C:
+			if active := s.AltSpeedActive(); active != altSpeed {
+				altSpeed = active
+				s.log.Infof("Alternative speed profile active: %v", altSpeed)
+				s.applyRateLimits()
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"fmt"
 	"time"
+	"sync"
 	"strings"
 	"runtime"
 	"net/url"
@@ -84,6 +85,11 @@ type BTConfiguration struct {
 	BufferSize          int
 	MaxUploadRate       int
 	MaxDownloadRate     int
+	MaxBackgroundUploadRate   int
+	MaxBackgroundDownloadRate int
+	AltUploadRate       int
+	AltDownloadRate     int
+	BandwidthSchedule   []ScheduleEntry
 	LimitAfterBuffering bool
 	ConnectionsLimit    int
 	SessionSave         int
@@ -91,16 +97,36 @@ type BTConfiguration struct {
 	UpperListenPort     int
 	DownloadPath        string
 	TorrentsPath        string
+	BindInterface       string
+	KillSwitch          bool
+	EncryptionPolicy    int
+	EncryptionLevel     int
+	EncryptionFallback  bool
+	IPFilterSource      string
+	IPFilterRefresh     time.Duration
 	Proxy               *ProxySettings
 }
 
 type BTService struct {
 	Session           libtorrent.Session
 	config            *BTConfiguration
+	configLock        sync.RWMutex
 	log               *logging.Logger
 	libtorrentLog     *logging.Logger
 	alertsBroadcaster *broadcast.Broadcaster
 	dialogProgressBG  *xbmc.DialogProgressBG
+	resumePending     map[string]bool
+	resumePendingLock sync.Mutex
+	resumeDataSaved   chan interface{}
+	streaming         map[string]bool
+	streamingLock     sync.RWMutex
+	altSpeedOverride  int
+	buffered          bool
+	bandwidthLock     sync.Mutex
+	listenLock        sync.Mutex
+	boundAddress      string
+	killSwitchTripped bool
+	encryptionFallback bool
 	closing           chan interface{}
 }
 
@@ -116,17 +142,24 @@ func NewBTService(config BTConfiguration) *BTService {
 		libtorrentLog:     logging.MustGetLogger("libtorrent"),
 		alertsBroadcaster: broadcast.NewBroadcaster(),
 		config:            &config,
+		streaming:         make(map[string]bool),
 		closing:           make(chan interface{}),
 	}
 
-	if _, err := os.Stat(s.config.TorrentsPath); os.IsNotExist(err) {
-		if err := os.Mkdir(s.config.TorrentsPath, 0755); err != nil{
+	if _, err := os.Stat(s.getConfig().TorrentsPath); os.IsNotExist(err) {
+		if err := os.Mkdir(s.getConfig().TorrentsPath, 0755); err != nil{
 			s.log.Error("Unable to create Torrents folder")
 		}
 	}
 
 	s.configure()
+	s.Listen()
 	go s.saveResumeDataConsumer()
+	go s.saveMetadataConsumer()
+	go s.prioritiesLoop()
+	go s.bandwidthScheduler()
+	go s.watchBindInterface()
+	go s.ipFilterLoop()
 	go s.saveResumeDataLoop()
 	go s.alertsConsumer()
 	go s.logAlerts()
@@ -143,13 +176,28 @@ func NewBTService(config BTConfiguration) *BTService {
 
 func (s *BTService) Close() {
 	s.log.Info("Stopping BT Services...")
+	s.saveResumeDataOnClose()
 	close(s.closing)
 	libtorrent.DeleteSession(s.Session)
 }
 
+// getConfig returns the current configuration, which is replaced as a whole
+// and never modified once set.
+func (s *BTService) getConfig() *BTConfiguration {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
+}
+
+func (s *BTService) setConfig(config *BTConfiguration) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.config = config
+}
+
 func (s *BTService) Reconfigure(config BTConfiguration) {
 	s.stopServices()
-	s.config = &config
+	s.setConfig(&config)
 	s.configure()
 	s.Listen()
 	s.startServices()
@@ -169,21 +217,22 @@ func (s *BTService) configure() {
 	settings.SetAnnounceToAllTiers(true)
 	settings.SetConnectionSpeed(500)
 
-	if s.config.ConnectionsLimit > 0 {
-		settings.SetConnectionsLimit(s.config.ConnectionsLimit)
+	if s.getConfig().ConnectionsLimit > 0 {
+		settings.SetConnectionsLimit(s.getConfig().ConnectionsLimit)
 	}
 
-	if s.config.LimitAfterBuffering == false {
-		if s.config.MaxDownloadRate > 0 {
-			s.log.Infof("Rate limiting download to %dkb/s", s.config.MaxDownloadRate / 1024)
-			settings.SetDownloadRateLimit(s.config.MaxDownloadRate)
-		}
-		if s.config.MaxUploadRate > 0 {
-			s.log.Infof("Rate limiting upload to %dkb/s", s.config.MaxUploadRate / 1024)
-			// If we have an upload rate, use the nicer bittyrant choker
-			settings.SetChokingAlgorithm(int(libtorrent.SessionSettingsBittyrantChoker))
-			settings.SetUploadRateLimit(s.config.MaxUploadRate)
-		}
+	downloadRate, uploadRate := s.currentRateLimits()
+	if downloadRate > 0 {
+		s.log.Infof("Rate limiting download to %dkb/s", downloadRate / 1024)
+		settings.SetDownloadRateLimit(downloadRate)
+	}
+	if s.getConfig().MaxUploadRate > 0 || s.getConfig().AltUploadRate > 0 {
+		// If we have an upload rate, use the nicer bittyrant choker
+		settings.SetChokingAlgorithm(int(libtorrent.SessionSettingsBittyrantChoker))
+	}
+	if uploadRate > 0 {
+		s.log.Infof("Rate limiting upload to %dkb/s", uploadRate / 1024)
+		settings.SetUploadRateLimit(uploadRate)
 	}
 
 	settings.SetPeerTos(ipToSLowCost)
@@ -220,38 +269,32 @@ func (s *BTService) configure() {
 	// Add all the libtorrent extensions
 	s.Session.AddExtensions()
 
-	s.log.Info("Setting Encryption settings...")
-	encryptionSettings := libtorrent.NewPeSettings()
-	defer libtorrent.DeletePeSettings(encryptionSettings)
-	encryptionSettings.SetOutEncPolicy(byte(libtorrent.PeSettingsForced))
-	encryptionSettings.SetInEncPolicy(byte(libtorrent.PeSettingsForced))
-	encryptionSettings.SetAllowedEncLevel(byte(libtorrent.PeSettingsBoth))
-	encryptionSettings.SetPreferRc4(true)
-	s.Session.SetPeSettings(encryptionSettings)
+	s.configureEncryption()
+
+	s.configureProxy()
+}
 
-	if s.config.Proxy != nil {
+func (s *BTService) configureProxy() {
+	if s.getConfig().Proxy == nil {
+		proxy := libtorrent.NewProxySettings()
+		defer libtorrent.DeleteProxySettings(proxy)
+		proxy.SetType(byte(ProxyTypeNone))
+		s.Session.SetProxy(proxy)
+	} else {
 		s.log.Info("Setting Proxy settings...")
 		proxy := libtorrent.NewProxySettings()
 		defer libtorrent.DeleteProxySettings(proxy)
-		proxy.SetHostname(s.config.Proxy.Hostname)
-		proxy.SetPort(uint16(s.config.Proxy.Port))
-		proxy.SetUsername(s.config.Proxy.Username)
-		proxy.SetPassword(s.config.Proxy.Password)
-		proxy.SetType(byte(s.config.Proxy.Type))
+		proxy.SetHostname(s.getConfig().Proxy.Hostname)
+		proxy.SetPort(uint16(s.getConfig().Proxy.Port))
+		proxy.SetUsername(s.getConfig().Proxy.Username)
+		proxy.SetPassword(s.getConfig().Proxy.Password)
+		proxy.SetType(byte(s.getConfig().Proxy.Type))
 		proxy.SetProxyHostnames(true)
 		proxy.SetProxyPeerConnections(true)
 		s.Session.SetProxy(proxy)
 	}
 }
 
-func (s *BTService) Listen() {
-	errCode := libtorrent.NewErrorCode()
-	defer libtorrent.DeleteErrorCode(errCode)
-	ports := libtorrent.NewStdPairIntInt(s.config.LowerListenPort, s.config.UpperListenPort)
-	defer libtorrent.DeleteStdPairIntInt(ports)
-	s.Session.ListenOn(ports, errCode)
-}
-
 func (s *BTService) WriteState(f io.Writer) error {
 	entry := libtorrent.NewEntry()
 	defer libtorrent.DeleteEntry(entry)
@@ -310,7 +353,7 @@ func (s *BTService) stopServices() {
 }
 
 func (s *BTService) saveResumeDataLoop() {
-	saveResumeWait := time.NewTicker(time.Duration(s.config.SessionSave) * time.Second)
+	saveResumeWait := time.NewTicker(time.Duration(s.getConfig().SessionSave) * time.Second)
 	defer saveResumeWait.Stop()
 
 	for {
@@ -332,6 +375,8 @@ func (s *BTService) saveResumeDataLoop() {
 
 				torrentHandle.SaveResumeData(1)
 			}
+		case <-s.closing:
+			return
 		}
 	}
 }
@@ -358,8 +403,16 @@ func (s *BTService) saveResumeDataConsumer() {
 				bEncoded := []byte(libtorrent.Bencode(entry))
 
 				s.log.Infof("Saving resume data for %s to %s.fastresume", torrentName, infoHash)
-				path := filepath.Join(s.config.TorrentsPath, fmt.Sprintf("%s.fastresume", infoHash))
-				ioutil.WriteFile(path, bEncoded, 0644)
+				path := filepath.Join(s.getConfig().TorrentsPath, fmt.Sprintf("%s.fastresume", infoHash))
+				if err := writeFileAtomic(path, bEncoded, 0644); err != nil {
+					s.log.Errorf("Unable to save resume data for %s: %s", torrentName, err)
+				}
+				s.resumeDataDone(infoHash)
+				break
+			case libtorrent.SaveResumeDataFailedAlertAlertType:
+				s.log.Warningf("Unable to save resume data: %s", alert.Message())
+				torrentHandle := libtorrent.SwigcptrTorrentAlert(alert.Swigcptr()).GetHandle()
+				s.resumeDataDone(hex.EncodeToString([]byte(torrentHandle.InfoHash().ToString())))
 				break
 			}
 		}
@@ -367,7 +420,9 @@ func (s *BTService) saveResumeDataConsumer() {
 }
 
 func (s *BTService) loadFastResumeFiles() error {
-	pattern := filepath.Join(s.config.TorrentsPath, "*.fastresume")
+	s.cleanOrphanedResumeFiles()
+
+	pattern := filepath.Join(s.getConfig().TorrentsPath, "*.fastresume")
 	files, _ := filepath.Glob(pattern)
 	for _, fastResumeFile := range files {
 		torrentParams := libtorrent.NewAddTorrentParams()
@@ -387,11 +442,16 @@ func (s *BTService) loadFastResumeFiles() error {
 		}
 		magnet += "&" + boosters.Encode()
 		torrentParams.SetUrl(magnet)
-		torrentParams.SetSavePath(s.config.DownloadPath)
+		torrentParams.SetSavePath(s.getConfig().DownloadPath)
+
+		if torrentInfo := loadTorrentInfo(s.getConfig().TorrentsPath, infoHash); torrentInfo != nil {
+			torrentParams.SetTorrentInfo(torrentInfo)
+		}
 
-		fastResumeData, err := ioutil.ReadFile(fastResumeFile)
+		fastResumeData, _, err := readResumeFile(fastResumeFile)
 		if err != nil {
-			return err
+			s.log.Warningf("Skipping invalid fast resume file %s: %s", fastResumeFile, err)
+			continue
 		}
 		fastResumeVector := libtorrent.NewStdVectorChar()
 		for _, c := range fastResumeData {
@@ -409,6 +469,38 @@ func (s *BTService) loadFastResumeFiles() error {
 	return nil
 }
 
+// QueueTorrent adds uri to the session for background downloading, without
+// starting a player.
+func (s *BTService) QueueTorrent(uri string) (string, error) {
+	torrentParams := libtorrent.NewAddTorrentParams()
+	defer libtorrent.DeleteAddTorrentParams(torrentParams)
+
+	torrent := NewTorrent(uri)
+	magnet := torrent.Magnet()
+	infoHash := torrent.InfoHash
+	boosters := url.Values{
+		"tr": DefaultTrackers,
+	}
+	magnet += "&" + boosters.Encode()
+	torrentParams.SetUrl(magnet)
+	torrentParams.SetSavePath(s.getConfig().DownloadPath)
+
+	saveResolved(s.getConfig().TorrentsPath, infoHash)
+	if torrentInfo := loadTorrentInfo(s.getConfig().TorrentsPath, infoHash); torrentInfo != nil {
+		torrentParams.SetTorrentInfo(torrentInfo)
+	}
+
+	s.log.Infof("Queueing %s", infoHash)
+	torrentHandle := s.Session.AddTorrent(torrentParams)
+	if torrentHandle == nil {
+		return "", fmt.Errorf("Unable to add torrent with URI %s", uri)
+	}
+	torrentHandle.AutoManaged(true)
+	s.applyPriorities()
+
+	return infoHash, nil
+}
+
 func (s *BTService) downloadProgress() {
 	rotateTicker := time.NewTicker(5 * time.Second)
 	defer rotateTicker.Stop()
@@ -2,16 +2,17 @@ package bittorrent
 
 import (
 	"crypto/sha1"
-	"crypto/tls"
 	"encoding/base32"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
 
+	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 	"github.com/zeebo/bencode"
 )
@@ -129,18 +130,6 @@ var (
 	Codecs = []string{"", "Xvid", "h264", "MP3", "AAC", "AC3", "DTS", "DTS HD", "DTS HD MA"}
 )
 
-var (
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-)
-
-const (
-	torCache = "http://torcache.net/torrent/%s.torrent"
-)
-
 // Used to avoid infinite recursion in UnmarshalJSON
 type torrent Torrent
 
@@ -154,6 +143,25 @@ func (t *Torrent) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+var infoHashRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ParseInfoHash returns the lower-cased hex form of a hex or base32
+// info-hash, failing on anything else.
+func ParseInfoHash(hash string) (string, error) {
+	if len(hash) == 32 {
+		unBase32Hash, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err != nil {
+			return "", fmt.Errorf("Invalid info-hash %q", hash)
+		}
+		return hex.EncodeToString(unBase32Hash), nil
+	}
+	hash = strings.ToLower(hash)
+	if infoHashRegexp.MatchString(hash) == false {
+		return "", fmt.Errorf("Invalid info-hash %q", hash)
+	}
+	return hash, nil
+}
+
 func (t *Torrent) initializeFromMagnet() {
 	magnetURI, _ := url.Parse(t.URI)
 	vals := magnetURI.Query()
@@ -210,16 +218,27 @@ func (t *Torrent) Resolve() error {
 		}
 	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := util.NewInsecureHTTPClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-	dec := bencode.NewDecoder(resp.Body)
 
 	// FIXME!!!!
-	if err := dec.Decode(&torrentFile); err != nil {
+	if err := bencode.DecodeBytes(data, &torrentFile); err != nil {
 		return err
 	}
+	infoHash, err := rememberResolved(data)
+	if err != nil {
+		metadataLog.Warningf("Invalid metadata from %s: %s", uri, err)
+	}
+	if t.InfoHash == "" {
+		t.InfoHash = infoHash
+	}
 	if t.InfoHash == "" {
 		hasher := sha1.New()
 		bencode.NewEncoder(hasher).Encode(torrentFile.Info)
@@ -292,7 +311,7 @@ func (t *Torrent) Magnet() string {
 		t.Resolve()
 	}
 	if t.IsMagnet() {
-		return t.URI + "&" + url.Values{"as": []string{fmt.Sprintf(torCache, t.InfoHash)}}.Encode()
+		return t.URI + "&" + url.Values{"as": metadataCacheURLs(t.InfoHash)}.Encode()
 	}
 	params := url.Values{}
 	params.Set("dn", t.Name)
@@ -2,28 +2,45 @@ package cache
 
 import (
 	"crypto/sha1"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"io"
+	"fmt"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"sync"
+	"strings"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
 )
 
 const (
 	DEFAULT              = time.Duration(0)
 	FOREVER              = time.Duration(-1)
 	CACHE_MIDDLEWARE_KEY = "gincontrib.cache"
+
+	// Set on the internal request refreshing a stale page, to revalidateToken
+	revalidateHeader  = "X-Quasar-Revalidate"
+	revalidateTimeout = 2 * time.Minute
 )
 
 var (
 	PageCachePrefix = "io.scakemyer.quasar.page.cache"
+	TraktPageCachePrefix = PageCachePrefix + ".trakt"
 	ErrCacheMiss    = errors.New("cache: key not found.")
 	ErrNotStored    = errors.New("cache: not stored.")
 	ErrNotSupport   = errors.New("cache: not support.")
 	log             = logging.MustGetLogger("btplayer")
+
+	revalidating     = map[string]bool{}
+	revalidatingLock = sync.Mutex{}
+	revalidateToken  = newRevalidateToken()
 )
 
 type CacheStore interface {
@@ -38,11 +55,17 @@ type CacheStore interface {
 }
 
 type responseCache struct {
-	Status int
-	Header http.Header
-	Data   []byte
+	Status  int
+	Header  http.Header
+	Data    []byte
+	ETag    string
+	Expires time.Time
 }
 
+// VaryFunc returns what else than the URL a cached page depends on, e.g. the
+// language, so each variant gets its own key.
+type VaryFunc func(ctx *gin.Context) string
+
 type cachedWriter struct {
 	gin.ResponseWriter
 	status  int
@@ -50,6 +73,7 @@ type cachedWriter struct {
 	store   CacheStore
 	expire  time.Duration
 	key     string
+	body    bytes.Buffer
 }
 
 func cacheKey(prefix string, u string) string {
@@ -58,14 +82,33 @@ func cacheKey(prefix string, u string) string {
 	return prefix + ":" + hex.EncodeToString(h.Sum(nil))
 }
 
+// ETag returns a strong entity tag for a response body.
+func ETag(data []byte) string {
+	h := sha1.New()
+	h.Write(data)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// ETagMatches tells whether an If-None-Match header matches tag.
+func ETagMatches(ifNoneMatch string, tag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
 func newCachedWriter(store CacheStore, expire time.Duration, writer gin.ResponseWriter, key string) *cachedWriter {
-	return &cachedWriter{writer, 0, false, store, expire, key}
+	return &cachedWriter{ResponseWriter: writer, store: store, expire: expire, key: key}
 }
 
+// WriteHeader and Write only buffer the response, which is sent by finish
+// once its ETag is known.
 func (w *cachedWriter) WriteHeader(code int) {
 	w.status = code
 	w.written = true
-	w.ResponseWriter.WriteHeader(code)
 }
 
 func (w *cachedWriter) Status() int {
@@ -77,42 +120,138 @@ func (w *cachedWriter) Written() bool {
 }
 
 func (w *cachedWriter) Write(data []byte) (int, error) {
-	ret, err := w.ResponseWriter.Write(data)
-	if err == nil {
-		//cache response
-		store := w.store
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *cachedWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+// finish sends the buffered response once the handlers are done, and caches
+// it if it's a complete, successful one.
+func (w *cachedWriter) finish(ctx *gin.Context) {
+	data := w.body.Bytes()
+	if w.status >= 200 && w.status < 300 && ctx.IsAborted() == false && len(ctx.Errors) == 0 {
+		tag := ETag(data)
+		w.Header().Set("ETag", tag)
 		val := responseCache{
-			w.status,
-			w.Header(),
-			data,
+			Status:  w.status,
+			Header:  w.Header(),
+			Data:    data,
+			ETag:    tag,
+			Expires: time.Now().UTC().Add(w.expire),
 		}
-		err = store.Set(w.key, val, w.expire)
-		if err != nil {
-			// need logger
+		// Pages are kept twice as long as they're fresh, so they can be served
+		// stale while they get refreshed
+		if err := w.store.Set(w.key, val, 2 * w.expire); err != nil {
+			log.Errorf("Unable to cache %s: %s", ctx.Request.URL.RequestURI(), err)
 		}
 	}
-	return ret, err
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.ResponseWriter.Write(data)
+}
+
+func newRevalidateToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// isRevalidation tells the refreshes made by revalidate apart from clients,
+// which must not be able to bypass the cache.
+func isRevalidation(req *http.Request) bool {
+	if req.Header.Get(revalidateHeader) != revalidateToken {
+		return false
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 // Cache Middleware
-func Cache(store CacheStore, expire time.Duration) gin.HandlerFunc {
+func Cache(store CacheStore, expire time.Duration, vary ...VaryFunc) gin.HandlerFunc {
+	return CacheWithPrefix(store, PageCachePrefix, expire, vary...)
+}
+
+// CacheWithPrefix is like Cache but keys the pages under prefix, so they can
+// be purged apart from the others.
+func CacheWithPrefix(store CacheStore, prefix string, expire time.Duration, vary ...VaryFunc) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var cache responseCache
-		key := cacheKey(PageCachePrefix, ctx.Request.URL.RequestURI())
-		if err := store.Get(key, &cache); err == nil {
+		uri := ctx.Request.URL.RequestURI()
+		variant := uri
+		for _, f := range vary {
+			variant += "|" + f(ctx)
+		}
+		key := cacheKey(prefix, variant)
+		if isRevalidation(ctx.Request) == false && store.Get(key, &cache) == nil {
+			if cache.Expires.Before(time.Now().UTC()) {
+				go revalidate(key, uri)
+			}
 			for k, vals := range cache.Header {
 				for _, v := range vals {
 					ctx.Writer.Header().Add(k, v)
 				}
 			}
+			if cache.ETag != "" {
+				ctx.Writer.Header().Set("ETag", cache.ETag)
+				if ETagMatches(ctx.Request.Header.Get("If-None-Match"), cache.ETag) {
+					ctx.AbortWithStatus(http.StatusNotModified)
+					return
+				}
+			}
 			ctx.AbortWithStatus(cache.Status)
 			ctx.Writer.Write(cache.Data)
 		} else {
 			// replace writer
 			writer := ctx.Writer
-			ctx.Writer = newCachedWriter(store, expire, ctx.Writer, key)
+			cachedWriter := newCachedWriter(store, expire, ctx.Writer, key)
+			ctx.Writer = cachedWriter
 			ctx.Next()
 			ctx.Writer = writer
+			cachedWriter.finish(ctx)
 		}
 	}
 }
+
+// revalidate refreshes a stale page by requesting it again from ourselves,
+// bypassing the cache. Concurrent refreshes of the same page are merged.
+func revalidate(key string, uri string) {
+	revalidatingLock.Lock()
+	if revalidating[key] {
+		revalidatingLock.Unlock()
+		return
+	}
+	revalidating[key] = true
+	revalidatingLock.Unlock()
+
+	defer func() {
+		revalidatingLock.Lock()
+		delete(revalidating, key)
+		revalidatingLock.Unlock()
+	}()
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d%s", config.ListenPort, uri), nil)
+	if err != nil {
+		log.Errorf("Unable to revalidate %s: %s", uri, err)
+		return
+	}
+	req.Header.Set(revalidateHeader, revalidateToken)
+	client := &http.Client{Timeout: revalidateTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("Unable to revalidate %s: %s", uri, err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+}
@@ -6,7 +6,17 @@ import (
 	"errors"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"time"
+	"io/ioutil"
+)
+
+const (
+	// Items being written, left out of items() until renamed in place
+	tempPrefix = "."
+	// Temporary files older than this were left by an interrupted write
+	tempMaxAge = 1 * time.Hour
 )
 
 type FileStore struct {
@@ -24,24 +34,35 @@ func NewFileStore(path string) *FileStore {
 	return &FileStore{path}
 }
 
+// Set writes the item to a temporary file first and renames it in place, so
+// that Get and Sweep never see it half-written.
 func (c *FileStore) Set(key string, value interface{}, expires time.Duration) error {
-	filename := path.Join(c.path, key)
-	file, err := os.Create(filename)
+	file, err := ioutil.TempFile(c.path, tempPrefix + key + ".")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpName := file.Name()
 
 	gzWriter := gzip.NewWriter(file)
-	defer gzWriter.Close()
-
 	item := fileStoreItem{
 		Key:     key,
 		Value:   value,
 		Expires: time.Now().UTC().Add(expires),
 	}
-
-	return json.NewEncoder(gzWriter).Encode(item)
+	err = json.NewEncoder(gzWriter).Encode(item)
+	if closeErr := gzWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path.Join(c.path, key))
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
 }
 
 func (c *FileStore) Add(key string, value interface{}, expires time.Duration) error {
@@ -59,15 +80,24 @@ func (c *FileStore) Replace(key string, value interface{}, expires time.Duration
 }
 
 func (c *FileStore) Get(key string, value interface{}) error {
-	file, err := os.Open(path.Join(c.path, key))
+	_, err := c.getItem(key, value)
+	return err
+}
+
+// getItem decodes the item into value and also returns its expiration.
+// Reading an item touches its file, so modification times follow the last
+// use and Trim can evict the least recently used items.
+func (c *FileStore) getItem(key string, value interface{}) (time.Time, error) {
+	filename := path.Join(c.path, key)
+	file, err := os.Open(filename)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	defer file.Close()
 
 	gzReader, err := gzip.NewReader(file)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	defer gzReader.Close()
 
@@ -75,15 +105,26 @@ func (c *FileStore) Get(key string, value interface{}) error {
 		Value: value,
 	}
 	if err = json.NewDecoder(gzReader).Decode(&item); err != nil {
-		return err
+		return time.Time{}, err
 	}
 	if item.Expires.Before(time.Now().UTC()) {
-		return errors.New("key is expired")
+		return time.Time{}, errors.New("key is expired")
 	}
-	return nil
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	return item.Expires, nil
+}
+
+// touch marks the item as used for Trim, for reads that don't go to disk.
+func (c *FileStore) touch(key string) {
+	now := time.Now()
+	os.Chtimes(path.Join(c.path, key), now, now)
 }
 
 func (c *FileStore) Delete(key string) error {
+	if err := os.Remove(path.Join(c.path, key)); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
 	return nil
 }
 
@@ -96,5 +137,131 @@ func (c *FileStore) Decrement(key string, delta uint64) (uint64, error) {
 }
 
 func (c *FileStore) Flush() error {
-	return ErrNotSupport
+	_, err := c.Purge("")
+	return err
+}
+
+func (c *FileStore) items() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), tempPrefix) {
+			if time.Since(info.ModTime()) > tempMaxAge {
+				os.Remove(path.Join(c.path, info.Name()))
+			}
+			continue
+		}
+		if info.Mode().IsRegular() {
+			items = append(items, info)
+		}
+	}
+	return items, nil
+}
+
+// Purge removes every item whose key starts with prefix and returns the
+// removed keys.
+func (c *FileStore) Purge(prefix string) ([]string, error) {
+	items, err := c.items()
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]string, 0)
+	for _, item := range items {
+		if strings.HasPrefix(item.Name(), prefix) == false {
+			continue
+		}
+		if err := os.Remove(path.Join(c.path, item.Name())); err == nil {
+			removed = append(removed, item.Name())
+		}
+	}
+	return removed, nil
+}
+
+func (c *FileStore) expires(key string) (time.Time, error) {
+	file, err := os.Open(path.Join(c.path, key))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer gzReader.Close()
+
+	var item struct {
+		Expires time.Time `json:"expires"`
+	}
+	if err = json.NewDecoder(gzReader).Decode(&item); err != nil {
+		return time.Time{}, err
+	}
+	return item.Expires, nil
+}
+
+// Sweep removes expired and unreadable items, and returns their keys.
+func (c *FileStore) Sweep() ([]string, error) {
+	items, err := c.items()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	removed := make([]string, 0)
+	for _, item := range items {
+		expires, err := c.expires(item.Name())
+		if err == nil && expires.After(now) {
+			continue
+		}
+		if err := os.Remove(path.Join(c.path, item.Name())); err == nil {
+			removed = append(removed, item.Name())
+		}
+	}
+	return removed, nil
+}
+
+type byModTime []os.FileInfo
+
+func (a byModTime) Len() int           { return len(a) }
+func (a byModTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byModTime) Less(i, j int) bool { return a[i].ModTime().Before(a[j].ModTime()) }
+
+func (c *FileStore) Size() (int64, error) {
+	items, err := c.items()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, item := range items {
+		size += item.Size()
+	}
+	return size, nil
+}
+
+// Trim removes the least recently used items until the store takes at most
+// maxSize bytes, and returns the removed keys.
+func (c *FileStore) Trim(maxSize int64) ([]string, error) {
+	items, err := c.items()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, item := range items {
+		size += item.Size()
+	}
+	sort.Sort(byModTime(items))
+
+	removed := make([]string, 0)
+	for _, item := range items {
+		if size <= maxSize {
+			break
+		}
+		if err := os.Remove(path.Join(c.path, item.Name())); err == nil {
+			size -= item.Size()
+			removed = append(removed, item.Name())
+		}
+	}
+	return removed, nil
 }
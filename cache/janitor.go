@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"time"
+	"sync/atomic"
+)
+
+const (
+	JanitorInterval = 30 * time.Minute
+)
+
+// Janitor sweeps expired items from disk every interval, and trims the store
+// down to maxSize() bytes if that's above zero. Items still in memory stay
+// valid until they expire, so only the disk copies are removed.
+func (c *LRUStore) Janitor(interval time.Duration, maxSize func() int64) {
+	for {
+		c.clean(maxSize())
+		time.Sleep(interval)
+	}
+}
+
+func (c *LRUStore) clean(maxSize int64) {
+	swept, err := c.file.Sweep()
+	if err != nil {
+		log.Errorf("Unable to sweep cache: %s", err)
+		return
+	}
+	atomic.AddUint64(&c.swept, uint64(len(swept)))
+
+	trimmed := []string{}
+	if maxSize > 0 {
+		if trimmed, err = c.file.Trim(maxSize); err != nil {
+			log.Errorf("Unable to trim cache to %d bytes: %s", maxSize, err)
+			return
+		}
+		atomic.AddUint64(&c.trimmed, uint64(len(trimmed)))
+	}
+
+	if len(swept) > 0 || len(trimmed) > 0 {
+		log.Infof("Cache cleaned, %d expired and %d least recently used items removed", len(swept), len(trimmed))
+	}
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"sync"
+	"time"
+	"encoding/json"
+	"container/list"
+	"sync/atomic"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DefaultLRUSize = 1024
+	// How often memory hits touch the file of an item, so that Trim keeps
+	// the items used the most.
+	touchInterval = 10 * time.Minute
+)
+
+var (
+	sharedStores     = map[string]*LRUStore{}
+	sharedStoresLock = sync.Mutex{}
+)
+
+// LRUStore keeps the most recently used items of a FileStore in memory,
+// as JSON so callers never share decoded values.
+type LRUStore struct {
+	file     *FileStore
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+
+	memoryHits uint64
+	fileHits   uint64
+	misses     uint64
+	evictions  uint64
+	swept      uint64
+	trimmed    uint64
+}
+
+type lruItem struct {
+	key     string
+	data    []byte
+	expires time.Time
+	touched time.Time
+}
+
+type CacheStats struct {
+	MemoryHits uint64 `json:"memory_hits"`
+	FileHits   uint64 `json:"file_hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+	Swept      uint64 `json:"swept"`
+	Trimmed    uint64 `json:"trimmed"`
+	Items      int    `json:"items"`
+	Capacity   int    `json:"capacity"`
+	DiskSize   int64  `json:"disk_size"`
+}
+
+func NewLRUStore(file *FileStore, capacity int) *LRUStore {
+	return &LRUStore{
+		file:     file,
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// SharedStore returns the single LRUStore for a cache directory, so every
+// package reuses the same memory cache.
+func SharedStore(path string) *LRUStore {
+	path = filepath.Clean(path)
+	sharedStoresLock.Lock()
+	defer sharedStoresLock.Unlock()
+	if store, ok := sharedStores[path]; ok {
+		return store
+	}
+	store := NewLRUStore(NewFileStore(path), DefaultLRUSize)
+	sharedStores[path] = store
+	return store
+}
+
+func (c *LRUStore) remember(key string, data []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*lruItem)
+		item.data = data
+		item.expires = expires
+		item.touched = time.Now()
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{key, data, expires, time.Now()})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+func (c *LRUStore) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
+
+// lookup returns the item from memory, and whether its file is due a touch.
+func (c *LRUStore) lookup(key string) ([]byte, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false, false
+	}
+	item := element.Value.(*lruItem)
+	if item.expires.Before(time.Now().UTC()) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false, false
+	}
+	c.order.MoveToFront(element)
+	touch := time.Since(item.touched) > touchInterval
+	if touch {
+		item.touched = time.Now()
+	}
+	return item.data, true, touch
+}
+
+func (c *LRUStore) Get(key string, value interface{}) error {
+	if data, ok, touch := c.lookup(key); ok {
+		atomic.AddUint64(&c.memoryHits, 1)
+		if touch {
+			c.file.touch(key)
+		}
+		return json.Unmarshal(data, value)
+	}
+
+	expires, err := c.file.getItem(key, value)
+	if err != nil {
+		atomic.AddUint64(&c.misses, 1)
+		return err
+	}
+	atomic.AddUint64(&c.fileHits, 1)
+	if data, err := json.Marshal(value); err == nil {
+		c.remember(key, data, expires)
+	}
+	return nil
+}
+
+func (c *LRUStore) Set(key string, value interface{}, expires time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.remember(key, data, time.Now().UTC().Add(expires))
+	return c.file.Set(key, value, expires)
+}
+
+func (c *LRUStore) Add(key string, value interface{}, expires time.Duration) error {
+	if _, ok, _ := c.lookup(key); ok {
+		return ErrNotStored
+	}
+	if err := c.file.Add(key, value, expires); err != nil {
+		return err
+	}
+	if data, err := json.Marshal(value); err == nil {
+		c.remember(key, data, time.Now().UTC().Add(expires))
+	}
+	return nil
+}
+
+func (c *LRUStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := c.file.Replace(key, value, expires); err != nil {
+		return err
+	}
+	if data, err := json.Marshal(value); err == nil {
+		c.remember(key, data, time.Now().UTC().Add(expires))
+	}
+	return nil
+}
+
+func (c *LRUStore) Delete(key string) error {
+	c.forget(key)
+	return c.file.Delete(key)
+}
+
+func (c *LRUStore) Increment(key string, delta uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+func (c *LRUStore) Decrement(key string, delta uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+func (c *LRUStore) Flush() error {
+	c.mu.Lock()
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.mu.Unlock()
+	return c.file.Flush()
+}
+
+// Purge removes every item whose key starts with prefix, in memory and on
+// disk, and returns how many files were removed.
+func (c *LRUStore) Purge(prefix string) (int, error) {
+	c.mu.Lock()
+	for key, element := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(element)
+			delete(c.items, key)
+		}
+	}
+	c.mu.Unlock()
+
+	removed, err := c.file.Purge(prefix)
+	return len(removed), err
+}
+
+func (c *LRUStore) Stats() CacheStats {
+	c.mu.Lock()
+	items := c.order.Len()
+	c.mu.Unlock()
+	diskSize, _ := c.file.Size()
+	return CacheStats{
+		MemoryHits: atomic.LoadUint64(&c.memoryHits),
+		FileHits:   atomic.LoadUint64(&c.fileHits),
+		Misses:     atomic.LoadUint64(&c.misses),
+		Evictions:  atomic.LoadUint64(&c.evictions),
+		Swept:      atomic.LoadUint64(&c.swept),
+		Trimmed:    atomic.LoadUint64(&c.trimmed),
+		Items:      items,
+		Capacity:   c.capacity,
+		DiskSize:   diskSize,
+	}
+}
@@ -30,12 +30,34 @@ type Configuration struct {
 	BufferSize          int
 	UploadRateLimit     int
 	DownloadRateLimit   int
+	BackgroundUploadRateLimit   int
+	BackgroundDownloadRateLimit int
+	AltUploadRateLimit          int
+	AltDownloadRateLimit        int
+	BandwidthSchedule           string
 	LimitAfterBuffering bool
 	BTListenPortMin     int
 	BTListenPortMax     int
 	ConnectionsLimit    int
 	SessionSave         int
 	TMDBApiKey          string
+	FanartApiKey        string
+	TVDBApiKey          string
+	TVDBPin             string
+	MetadataCaches      []string
+	BindInterface       string
+	KillSwitch          bool
+	EncryptionPolicy    int
+	EncryptionLevel     int
+	EncryptionFallback  bool
+	IPFilterSource      string
+	IPFilterRefresh     int
+	CacheMaxSize        int
+	TraktSyncInterval   int
+	TraktSyncWatchlist  bool
+	TraktSyncCollection bool
+	TraktSyncLists      []string
+	TraktSyncPush       bool
 
 	SortingModeMovies            int
 	SortingModeShows             int
@@ -47,10 +69,18 @@ type Configuration struct {
 	CustomProviderTimeout        int
 
 	SocksEnabled  bool
+	SocksType     int
 	SocksHost     string
 	SocksPort     int
 	SocksLogin    string
 	SocksPassword string
+
+	HTTPProxyEnabled  bool
+	HTTPProxyType     int
+	HTTPProxyHost     string
+	HTTPProxyPort     int
+	HTTPProxyLogin    string
+	HTTPProxyPassword string
 }
 
 var config = &Configuration{}
@@ -60,6 +90,13 @@ const (
 	ListenPort = 65251
 )
 
+// Proxy types as listed in the settings
+const (
+	ProxyTypeSocks4 = iota
+	ProxyTypeSocks5
+	ProxyTypeHTTP
+)
+
 func Get() *Configuration {
 	lock.RLock()
 	defer lock.RUnlock()
@@ -95,11 +132,16 @@ func Reload() *Configuration {
 		TorrentsPath:        filepath.Join(downloadPath, "Torrents"),
 		Info:                info,
 		Platform:            platform,
-		Language:            xbmc.GetLanguageISO_639_1(),
+		Language:            xbmc.GetRegionalLanguage(),
 		ProfilePath:         info.Profile,
 		BufferSize:          xbmc.GetSettingInt("buffer_size") * 1024 * 1024,
 		UploadRateLimit:     xbmc.GetSettingInt("max_upload_rate") * 1024,
 		DownloadRateLimit:   xbmc.GetSettingInt("max_download_rate") * 1024,
+		BackgroundUploadRateLimit:   xbmc.GetSettingInt("background_upload_rate") * 1024,
+		BackgroundDownloadRateLimit: xbmc.GetSettingInt("background_download_rate") * 1024,
+		AltUploadRateLimit:          xbmc.GetSettingInt("alt_upload_rate") * 1024,
+		AltDownloadRateLimit:        xbmc.GetSettingInt("alt_download_rate") * 1024,
+		BandwidthSchedule:           xbmc.GetSettingString("bandwidth_schedule"),
 		LimitAfterBuffering: xbmc.GetSettingBool("limit_after_buffering"),
 		BackgroundHandling:  xbmc.GetSettingBool("background_handling"),
 		KeepFilesAfterStop:  xbmc.GetSettingBool("keep_files"),
@@ -113,6 +155,23 @@ func Reload() *Configuration {
 		ConnectionsLimit:    xbmc.GetSettingInt("connections_limit"),
 		SessionSave:         xbmc.GetSettingInt("session_save"),
 		TMDBApiKey:          xbmc.GetSettingString("tmdb_api_key"),
+		FanartApiKey:        xbmc.GetSettingString("fanart_api_key"),
+		TVDBApiKey:          xbmc.GetSettingString("tvdb_api_key"),
+		TVDBPin:             xbmc.GetSettingString("tvdb_pin"),
+		MetadataCaches:      metadataCaches(xbmc.GetSettingString("metadata_caches")),
+		BindInterface:       xbmc.GetSettingString("bind_interface"),
+		KillSwitch:          xbmc.GetSettingBool("kill_switch"),
+		EncryptionPolicy:    xbmc.GetSettingInt("encryption_policy"),
+		EncryptionLevel:     xbmc.GetSettingInt("encryption_level"),
+		EncryptionFallback:  xbmc.GetSettingBool("encryption_fallback"),
+		IPFilterSource:      xbmc.GetSettingString("ip_filter_source"),
+		IPFilterRefresh:     xbmc.GetSettingInt("ip_filter_refresh"),
+		CacheMaxSize:        xbmc.GetSettingInt("cache_max_size"),
+		TraktSyncInterval:   xbmc.GetSettingInt("trakt_sync"),
+		TraktSyncWatchlist:  xbmc.GetSettingBool("trakt_sync_watchlist"),
+		TraktSyncCollection: xbmc.GetSettingBool("trakt_sync_collection"),
+		TraktSyncLists:      splitList(xbmc.GetSettingString("trakt_sync_lists")),
+		TraktSyncPush:       xbmc.GetSettingBool("trakt_sync_push"),
 
 		SortingModeMovies:            xbmc.GetSettingInt("sorting_mode_movies"),
 		SortingModeShows:             xbmc.GetSettingInt("sorting_mode_shows"),
@@ -124,10 +183,18 @@ func Reload() *Configuration {
 		CustomProviderTimeout:        xbmc.GetSettingInt("custom_provider_timeout"),
 
 		SocksEnabled:  xbmc.GetSettingBool("socks_enabled"),
+		SocksType:     xbmc.GetSettingInt("socks_type"),
 		SocksHost:     xbmc.GetSettingString("socks_host"),
 		SocksPort:     xbmc.GetSettingInt("socks_port"),
 		SocksLogin:    xbmc.GetSettingString("socks_login"),
 		SocksPassword: xbmc.GetSettingString("socks_password"),
+
+		HTTPProxyEnabled:  xbmc.GetSettingBool("http_proxy_enabled"),
+		HTTPProxyType:     xbmc.GetSettingInt("http_proxy_type"),
+		HTTPProxyHost:     xbmc.GetSettingString("http_proxy_host"),
+		HTTPProxyPort:     xbmc.GetSettingInt("http_proxy_port"),
+		HTTPProxyLogin:    xbmc.GetSettingString("http_proxy_login"),
+		HTTPProxyPassword: xbmc.GetSettingString("http_proxy_password"),
 	}
 
 	lock.Lock()
@@ -137,6 +204,39 @@ func Reload() *Configuration {
 	return config
 }
 
+// RestorePaths puts back the download paths still in use, for when the new
+// ones can't be applied yet.
+func RestorePaths(downloadPath string, torrentsPath string) {
+	lock.Lock()
+	defer lock.Unlock()
+	restored := *config
+	restored.DownloadPath = downloadPath
+	restored.TorrentsPath = torrentsPath
+	config = &restored
+}
+
+// splitList turns a comma or whitespace separated setting into a list,
+// dropping empty entries.
+func splitList(setting string) []string {
+	return strings.FieldsFunc(setting, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
+
+// metadataCaches keeps the cache URL templates that have a single %s for
+// the info-hash.
+func metadataCaches(setting string) []string {
+	caches := make([]string, 0)
+	for _, cache := range splitList(setting) {
+		if strings.Count(cache, "%s") != 1 || strings.Count(cache, "%") != 1 {
+			log.Warningf("Ignoring metadata cache %s, it needs a single %%s for the info-hash", cache)
+			continue
+		}
+		caches = append(caches, cache)
+	}
+	return caches
+}
+
 func AddonIcon() string {
 	return filepath.Join(Get().Info.Path, "icon.png")
 }
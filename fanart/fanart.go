@@ -0,0 +1,124 @@
+package fanart
+
+import (
+	"fmt"
+	"path"
+	"time"
+	"errors"
+	"strings"
+	"net/url"
+	"net/http"
+
+	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/util"
+)
+
+const (
+	fanartEndpoint          = "https://webservice.fanart.tv/v3"
+	burstRate               = 10
+	burstTime               = 1 * time.Second
+	simultaneousConnections = 5
+	cacheTime               = 7 * 24 * time.Hour
+)
+
+var (
+	ErrNoAPIKey = errors.New("No fanart.tv API key set")
+
+	rateLimiter = util.NewRateLimiter(burstRate, burstTime, simultaneousConnections)
+)
+
+type Image struct {
+	Id     string `json:"id"`
+	URL    string `json:"url"`
+	Lang   string `json:"lang"`
+	Likes  string `json:"likes"`
+	Season string `json:"season,omitempty"`
+}
+
+type Movie struct {
+	HDLogo     []*Image `json:"hdmovielogo"`
+	Logo       []*Image `json:"movielogo"`
+	HDClearArt []*Image `json:"hdmovieclearart"`
+	ClearArt   []*Image `json:"movieart"`
+	Banner     []*Image `json:"moviebanner"`
+	Thumb      []*Image `json:"moviethumb"`
+	Background []*Image `json:"moviebackground"`
+	Poster     []*Image `json:"movieposter"`
+}
+
+type Show struct {
+	HDLogo       []*Image `json:"hdtvlogo"`
+	Logo         []*Image `json:"clearlogo"`
+	HDClearArt   []*Image `json:"hdclearart"`
+	ClearArt     []*Image `json:"clearart"`
+	Banner       []*Image `json:"tvbanner"`
+	Thumb        []*Image `json:"tvthumb"`
+	Background   []*Image `json:"showbackground"`
+	Poster       []*Image `json:"tvposter"`
+	SeasonPoster []*Image `json:"seasonposter"`
+	SeasonThumb  []*Image `json:"seasonthumb"`
+	SeasonBanner []*Image `json:"seasonbanner"`
+}
+
+// get fetches and caches an endpoint. fanart.tv answers 404 for items
+// without artwork, which is cached as an empty result too.
+func get(endpoint string, result interface{}) error {
+	apiKey := config.Get().FanartApiKey
+	if apiKey == "" {
+		return ErrNoAPIKey
+	}
+
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
+	// Keys are file names, "movies/123" is kept as "com.fanart.movies.123"
+	key := "com.fanart." + strings.Replace(endpoint, "/", ".", -1)
+	if err := cacheStore.Get(key, result); err == nil {
+		return nil
+	}
+
+	params := url.Values{"api_key": []string{apiKey}}
+	var resp *napping.Response
+	var err error
+	rateLimiter.Call(func() {
+		session := napping.Session{Client: util.NewHTTPClient(0)}
+		resp, err = session.Get(fmt.Sprintf("%s/%s", fanartEndpoint, endpoint), &params, result, nil)
+	})
+	if err != nil {
+		return err
+	}
+	switch resp.Status() {
+	case http.StatusOK, http.StatusNotFound:
+		cacheStore.Set(key, result, cacheTime)
+		return nil
+	}
+	return fmt.Errorf("fanart.tv returned status %d for %s", resp.Status(), endpoint)
+}
+
+func GetMovie(tmdbId int) (*Movie, error) {
+	movie := &Movie{}
+	if err := get(fmt.Sprintf("movies/%d", tmdbId), movie); err != nil {
+		return nil, err
+	}
+	return movie, nil
+}
+
+func GetShow(tvdbId int) (*Show, error) {
+	show := &Show{}
+	if err := get(fmt.Sprintf("tv/%d", tvdbId), show); err != nil {
+		return nil, err
+	}
+	return show, nil
+}
+
+// ForSeason keeps the images of a season, along with the ones made for all
+// of them.
+func ForSeason(images []*Image, season int) []*Image {
+	seasonImages := make([]*Image, 0, len(images))
+	for _, image := range images {
+		if image.Season == "all" || image.Season == fmt.Sprintf("%d", season) {
+			seasonImages = append(seasonImages, image)
+		}
+	}
+	return seasonImages
+}
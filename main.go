@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -12,7 +13,9 @@ import (
 	"github.com/op/go-logging"
 	"github.com/scakemyer/quasar/api"
 	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/cache"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/trakt"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 )
@@ -40,6 +43,10 @@ func makeBTConfiguration(conf *config.Configuration) *bittorrent.BTConfiguration
 		BufferSize:          conf.BufferSize,
 		MaxUploadRate:       conf.UploadRateLimit,
 		MaxDownloadRate:     conf.DownloadRateLimit,
+		MaxBackgroundUploadRate:   conf.BackgroundUploadRateLimit,
+		MaxBackgroundDownloadRate: conf.BackgroundDownloadRateLimit,
+		AltUploadRate:             conf.AltUploadRateLimit,
+		AltDownloadRate:           conf.AltDownloadRateLimit,
 		LimitAfterBuffering: conf.LimitAfterBuffering,
 		ConnectionsLimit:    conf.ConnectionsLimit,
 		SessionSave:         conf.SessionSave,
@@ -47,11 +54,40 @@ func makeBTConfiguration(conf *config.Configuration) *bittorrent.BTConfiguration
 		UpperListenPort:     conf.BTListenPortMax,
 		DownloadPath:        conf.DownloadPath,
 		TorrentsPath:        conf.TorrentsPath,
+		BindInterface:       conf.BindInterface,
+		KillSwitch:          conf.KillSwitch,
+		EncryptionPolicy:    conf.EncryptionPolicy,
+		EncryptionLevel:     conf.EncryptionLevel,
+		EncryptionFallback:  conf.EncryptionFallback,
+		IPFilterSource:      conf.IPFilterSource,
+		IPFilterRefresh:     time.Duration(conf.IPFilterRefresh) * time.Hour,
+	}
+
+	if schedule, err := bittorrent.ParseBandwidthSchedule(conf.BandwidthSchedule); err != nil {
+		log.Errorf("Invalid bandwidth schedule: %s", err)
+	} else {
+		btConfig.BandwidthSchedule = schedule
 	}
 
 	if conf.SocksEnabled == true {
+		proxyType := bittorrent.ProxyTypeNone
+		withAuth := conf.SocksLogin != ""
+		switch conf.SocksType {
+		case config.ProxyTypeSocks4:
+			proxyType = bittorrent.ProxyTypeSocks4
+		case config.ProxyTypeSocks5:
+			proxyType = bittorrent.ProxyTypeSocks5
+			if withAuth {
+				proxyType = bittorrent.ProxyTypeSocks5Password
+			}
+		case config.ProxyTypeHTTP:
+			proxyType = bittorrent.ProxyTypeSocksHTTP
+			if withAuth {
+				proxyType = bittorrent.ProxyTypeSocksHTTPPassword
+			}
+		}
 		btConfig.Proxy = &bittorrent.ProxySettings{
-			Type:     bittorrent.ProxyTypeSocks5Password,
+			Type:     proxyType,
 			Hostname: conf.SocksHost,
 			Port:     conf.SocksPort,
 			Username: conf.SocksLogin,
@@ -88,6 +124,13 @@ func main() {
 
 	btService := bittorrent.NewBTService(*makeBTConfiguration(conf))
 
+	// cache_max_size is in MB, 0 for no limit
+	go cache.SharedStore(path.Join(conf.ProfilePath, "cache")).Janitor(cache.JanitorInterval, func() int64 {
+		return int64(config.Get().CacheMaxSize) * 1024 * 1024
+	})
+	go trakt.ScrobbleRetryLoop(trakt.ScrobbleRetryInterval)
+	go api.LibrarySyncLoop()
+
 	var shutdown = func() {
 		log.Info("Shutting down...")
 		btService.Close()
@@ -114,7 +157,12 @@ func main() {
 		handler.ServeHTTP(w, r)
 	}))
 	http.Handle("/reload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		btService.Reconfigure(*makeBTConfiguration(config.Reload()))
+		if err := btService.ApplyConfiguration(*makeBTConfiguration(config.Reload())); err != nil {
+			// Keep serving files from where the service downloads them
+			config.RestorePaths(btService.Paths())
+			xbmc.Notify("Quasar", "LOCALIZE[30304]", config.AddonIcon())
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
 	}))
 	http.Handle("/shutdown", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		shutdown()
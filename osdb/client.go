@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/kolo/xmlrpc"
+	"github.com/scakemyer/quasar/util"
 )
 
 const (
@@ -62,7 +63,7 @@ func (m Movies) Empty() bool {
 }
 
 func NewClient() (*Client, error) {
-	rpc, err := xmlrpc.NewClient(DefaultOSDBServer, nil)
+	rpc, err := xmlrpc.NewClient(DefaultOSDBServer, util.HTTPTransport())
 	if err != nil {
 		return nil, err
 	}
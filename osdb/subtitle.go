@@ -7,10 +7,11 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"net/http"
 	"crypto/md5"
 	"compress/gzip"
 	"encoding/base64"
+
+	"github.com/scakemyer/quasar/util"
 )
 
 // A Subtitle with its many OSDB attributes...
@@ -81,7 +82,7 @@ func (subs Subtitles) Best() *Subtitle {
 }
 
 func NewSubtitleReader(s *Subtitle) (io.Reader, error) {
-	resp, err := http.Get(s.SubDownloadLink)
+	resp, err := util.NewHTTPClient(0).Get(s.SubDownloadLink)
 	if err != nil {
 		return nil, err
 	}
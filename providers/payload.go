@@ -34,6 +34,11 @@ type EpisodeSearchObject struct {
 	Episode        int               `json:"episode"`
 	Titles         map[string]string `json:"titles"`
 	AbsoluteNumber int               `json:"absolute_number"`
+	DVDSeason      int               `json:"dvd_season"`
+	DVDEpisode     int               `json:"dvd_episode"`
+	TVDBSeason     int               `json:"tvdb_season"`
+	TVDBEpisode    int               `json:"tvdb_episode"`
+	Anime          bool              `json:"anime"`
 }
 
 func (sp *SearchPayload) String() string {
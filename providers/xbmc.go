@@ -15,6 +15,7 @@ import (
 	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/tvdb"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 )
@@ -150,21 +151,47 @@ func (as *AddonSearcher) GetSeasonSearchObject(show *tmdb.Show, season *tmdb.Sea
 }
 
 func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.Episode) *EpisodeSearchObject {
-	absoluteNumber := 0 // FIXME
-
 	title := show.OriginalName
 	if title == "" {
 		title = show.Name
 	}
 
-	return &EpisodeSearchObject{
+	searchObject := &EpisodeSearchObject{
 		IMDBId:         show.ExternalIDs.IMDBId,
 		TVDBId:         show.ExternalIDs.TVDBID,
 		Title:          NormalizeTitle(title),
 		Season:         episode.SeasonNumber,
 		Episode:        episode.EpisodeNumber,
-		AbsoluteNumber: absoluteNumber,
 	}
+	as.addTVDBNumbering(searchObject, episode)
+	return searchObject
+}
+
+// addTVDBNumbering fills the absolute and alternative orderings of the
+// episode from TVDB, for providers searching "Show - 123" style releases.
+func (as *AddonSearcher) addTVDBNumbering(searchObject *EpisodeSearchObject, episode *tmdb.Episode) {
+	if searchObject.TVDBId == 0 {
+		return
+	}
+	tvdbShow, err := tvdb.NewShowCached(strconv.Itoa(searchObject.TVDBId), "en")
+	if err != nil {
+		if err != tvdb.ErrNoAPIKey {
+			as.log.Warningf("Unable to get TVDB show %d: %s", searchObject.TVDBId, err)
+		}
+		return
+	}
+	tvdbEpisode := tvdbShow.FindEpisode(episode.SeasonNumber, episode.EpisodeNumber, episode.AirDate)
+	if tvdbEpisode == nil {
+		as.log.Warningf("Unable to find S%02dE%02d in TVDB show %d", episode.SeasonNumber, episode.EpisodeNumber, searchObject.TVDBId)
+		return
+	}
+
+	searchObject.AbsoluteNumber = tvdbEpisode.AbsoluteNumber
+	searchObject.DVDSeason = tvdbEpisode.DVDSeason
+	searchObject.DVDEpisode = tvdbEpisode.DVDEpisode
+	searchObject.TVDBSeason = tvdbEpisode.SeasonNumber
+	searchObject.TVDBEpisode = tvdbEpisode.EpisodeNumber
+	searchObject.Anime = tvdbShow.AbsoluteNumbered() >= mixAbsoluteNumberPercentage
 }
 
 func (as *AddonSearcher) call(method string, searchObject interface{}) []*bittorrent.Torrent {
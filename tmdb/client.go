@@ -0,0 +1,107 @@
+package tmdb
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"strconv"
+	"net/http"
+
+	"github.com/jmcvetta/napping"
+)
+
+const (
+	maxRetries    = 3
+	maxRetryDelay = 30 * time.Second
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("TMDB API key is invalid")
+
+	retryBaseDelay = 1 * time.Second
+)
+
+// StatusError is returned when TMDB answers with an unexpected status.
+type StatusError struct {
+	Endpoint string
+	Status   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("TMDB returned status %d for %s", e.Status, e.Endpoint)
+}
+
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.Status == http.StatusNotFound
+}
+
+// retryAfter reads the delay TMDB asks for, either in seconds or as a date.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if resp == nil {
+		return fallback
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+	delay := fallback
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(time.Now())
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// get calls a TMDB endpoint with the API key, retrying network errors,
+// server errors and rate limiting with exponential backoff.
+func get(endpoint string, params napping.Params, result interface{}) error {
+	if params == nil {
+		params = napping.Params{}
+	}
+	if _, ok := params["api_key"]; !ok {
+		params["api_key"] = apiKey
+	}
+	urlValues := params.AsUrlValues()
+
+	delay := retryBaseDelay
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			tmdbLog.Warningf("Retrying %s in %s: %s", endpoint, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		var resp *napping.Response
+		rateLimiter.Call(func() {
+			resp, err = newSession().Get(tmdbEndpoint + endpoint, &urlValues, result, nil)
+		})
+		if err != nil {
+			continue
+		}
+
+		switch status := resp.Status(); {
+		case status == http.StatusOK:
+			return nil
+		case status == http.StatusUnauthorized:
+			return ErrInvalidAPIKey
+		case status == http.StatusTooManyRequests:
+			err = &StatusError{Endpoint: endpoint, Status: status}
+			delay = retryAfter(resp.HttpResponse(), delay)
+		case status >= 500:
+			err = &StatusError{Endpoint: endpoint, Status: status}
+		default:
+			return &StatusError{Endpoint: endpoint, Status: status}
+		}
+	}
+	tmdbLog.Errorf("Giving up on %s: %s", endpoint, err)
+	return err
+}
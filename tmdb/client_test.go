@@ -0,0 +1,144 @@
+package tmdb
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+)
+
+// fakeTMDB serves the given statuses in order, then keeps answering with the
+// last one, and counts the requests.
+func fakeTMDB(t *testing.T, statuses []int, header http.Header) (*int, func()) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[len(statuses) - 1]
+		if requests < len(statuses) {
+			status = statuses[requests]
+		}
+		requests++
+		if r.URL.Query().Get("api_key") == "" {
+			t.Errorf("Request to %s without API key", r.URL.Path)
+		}
+		for k, vals := range header {
+			for _, v := range vals {
+				w.Header().Add(k, v)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"id": 550, "title": "Fight Club"}`))
+		} else {
+			w.Write([]byte(`{"status_code": 7, "status_message": "Error"}`))
+		}
+	}))
+
+	previousEndpoint := tmdbEndpoint
+	previousDelay := retryBaseDelay
+	tmdbEndpoint = server.URL + "/"
+	retryBaseDelay = time.Millisecond
+	return &requests, func() {
+		tmdbEndpoint = previousEndpoint
+		retryBaseDelay = previousDelay
+		server.Close()
+	}
+}
+
+func TestGetRetriesServerErrors(t *testing.T) {
+	requests, done := fakeTMDB(t, []int{500, 503, 200}, nil)
+	defer done()
+
+	var movie Movie
+	if err := get("movie/550", nil, &movie); err != nil {
+		t.Fatalf("Expected success after retries, got %s", err)
+	}
+	if movie.Title != "Fight Club" {
+		t.Errorf("Expected the movie to be decoded, got %q", movie.Title)
+	}
+	if *requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", *requests)
+	}
+}
+
+func TestGetHonoursRetryAfter(t *testing.T) {
+	requests, done := fakeTMDB(t, []int{429, 200}, http.Header{"Retry-After": []string{"1"}})
+	defer done()
+
+	start := time.Now()
+	var movie Movie
+	if err := get("movie/550", nil, &movie); err != nil {
+		t.Fatalf("Expected success after rate limiting, got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected to wait for Retry-After, only waited %s", elapsed)
+	}
+	if *requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", *requests)
+	}
+}
+
+func TestGetInvalidAPIKey(t *testing.T) {
+	requests, done := fakeTMDB(t, []int{401}, nil)
+	defer done()
+
+	var movie Movie
+	if err := get("movie/550", nil, &movie); err != ErrInvalidAPIKey {
+		t.Errorf("Expected ErrInvalidAPIKey, got %v", err)
+	}
+	if *requests != 1 {
+		t.Errorf("Expected no retry, got %d requests", *requests)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	requests, done := fakeTMDB(t, []int{404}, nil)
+	defer done()
+
+	var movie Movie
+	err := get("movie/0", nil, &movie)
+	if IsNotFound(err) == false {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+	if statusErr, ok := err.(*StatusError); ok && statusErr.Endpoint != "movie/0" {
+		t.Errorf("Expected the endpoint in the error, got %q", statusErr.Endpoint)
+	}
+	if *requests != 1 {
+		t.Errorf("Expected no retry, got %d requests", *requests)
+	}
+}
+
+func TestGetGivesUpOnServerErrors(t *testing.T) {
+	requests, done := fakeTMDB(t, []int{502}, nil)
+	defer done()
+
+	var movie Movie
+	err := get("movie/550", nil, &movie)
+	statusErr, ok := err.(*StatusError)
+	if ok == false || statusErr.Status != 502 {
+		t.Errorf("Expected a 502 StatusError, got %v", err)
+	}
+	if *requests != maxRetries + 1 {
+		t.Errorf("Expected %d requests, got %d", maxRetries + 1, *requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	fallback := 2 * time.Second
+	resp := &http.Response{Header: http.Header{}}
+	if delay := retryAfter(resp, fallback); delay != fallback {
+		t.Errorf("Expected the fallback without header, got %s", delay)
+	}
+	resp.Header.Set("Retry-After", "5")
+	if delay := retryAfter(resp, fallback); delay != 5 * time.Second {
+		t.Errorf("Expected 5s, got %s", delay)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if delay := retryAfter(resp, fallback); delay != maxRetryDelay {
+		t.Errorf("Expected the delay to be capped, got %s", delay)
+	}
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	if delay := retryAfter(resp, fallback); delay != 0 {
+		t.Errorf("Expected no delay for a past date, got %s", delay)
+	}
+}
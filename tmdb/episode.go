@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"path"
 	"time"
-	"errors"
 	"math/rand"
 
 	"github.com/jmcvetta/napping"
@@ -13,39 +12,39 @@ import (
 	"github.com/scakemyer/quasar/xbmc"
 )
 
-func GetEpisode(showId int, seasonNumber int, episodeNumber int, language string) *Episode {
+func GetEpisode(showId int, seasonNumber int, episodeNumber int, language string) (*Episode, error) {
 	var episode *Episode
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.tmdb.episode.%d.%d.%s", showId, seasonNumber, episodeNumber, language)
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
+	key := fmt.Sprintf("com.tmdb.episode.%d.%d.%d.%s", showId, seasonNumber, episodeNumber, language)
 	if err := cacheStore.Get(key, &episode); err != nil {
-		rateLimiter.Call(func() {
-			urlValues := napping.Params{
-				"api_key": apiKey,
-				"append_to_response": "credits,images,videos,external_ids",
-				"language": language,
-			}.AsUrlValues()
-			resp, err := napping.Get(
-				fmt.Sprintf("%stv/%d/season/%d/episode/%d", tmdbEndpoint, showId, seasonNumber, episodeNumber),
-				&urlValues,
-				&episode,
-				nil,
-			)
-			if err != nil {
-				panic(err)
+		endpoint := fmt.Sprintf("tv/%d/season/%d/episode/%d", showId, seasonNumber, episodeNumber)
+		err = get(endpoint, napping.Params{
+			"append_to_response": "credits,images,videos,external_ids",
+			"language": language,
+		}, &episode)
+		if err != nil {
+			return nil, err
+		}
+		if episode == nil {
+			return nil, &StatusError{Endpoint: endpoint, Status: 404}
+		}
+
+		for _, fallback := range LanguageFallbacks(language)[1:] {
+			if episode.Name != "" && episode.Overview != "" {
+				break
 			}
-			if resp.Status() != 200 {
-				panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
+			if other, _ := GetEpisode(showId, seasonNumber, episodeNumber, fallback); other != nil {
+				if episode.Name == "" {
+					episode.Name = other.Name
+				}
+				if episode.Overview == "" {
+					episode.Overview = other.Overview
+				}
 			}
-		})
-
-		if episode != nil {
-			cacheStore.Set(key, episode, cacheTime)
 		}
+		cacheStore.Set(key, episode, cacheTime)
 	}
-	if episode == nil {
-		return nil
-	}
-	return episode
+	return episode, nil
 }
 
 func (episodes EpisodeList) ToListItems(show *Show, season *Season) []*xbmc.ListItem {
@@ -59,6 +58,7 @@ func (episodes EpisodeList) ToListItems(show *Show, season *Season) []*xbmc.List
 		fanarts = append(fanarts, ImageURL(backdrop.FilePath, "w1280"))
 	}
 
+	showArt := show.artworkSources()
 	now := time.Now().UTC()
 	for _, episode := range episodes {
 		if episode.AirDate == "" {
@@ -80,6 +80,8 @@ func (episodes EpisodeList) ToListItems(show *Show, season *Season) []*xbmc.List
 			}
 		}
 		item.Art.Poster = ImageURL(season.Poster, "w500")
+		item.Art.Landscape = ImageURL(episode.StillPath, "w1280")
+		showArt.Season(season.Season, config.Get().Language, item.Art)
 
 		items = append(items, item)
 	}
@@ -5,12 +5,12 @@ import (
 	"path"
 	"sync"
 	"time"
-	"errors"
 	"strconv"
 	"strings"
 	"math/rand"
 
 	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/artwork"
 	"github.com/scakemyer/quasar/cache"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/xbmc"
@@ -22,40 +22,41 @@ const (
 	popularMoviesStartPage = 1
 )
 
-func GetMovie(tmdbId int, language string) *Movie {
+func GetMovie(tmdbId int, language string) (*Movie, error) {
 	return GetMovieById(strconv.Itoa(tmdbId), language)
 }
 
-func GetMovieById(movieId string, language string) *Movie {
+func GetMovieById(movieId string, language string) (*Movie, error) {
 	var movie *Movie
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
 	key := fmt.Sprintf("com.tmdb.movie.%s.%s", movieId, language)
 	if err := cacheStore.Get(key, &movie); err != nil {
-		rateLimiter.Call(func() {
-      urlValues := napping.Params{
-				"api_key": apiKey,
-				"append_to_response": "credits,images,alternative_titles,translations,external_ids,trailers",
-				"language": language,
-			}.AsUrlValues()
-			resp, err := napping.Get(
-				tmdbEndpoint + "movie/" + movieId,
-				&urlValues,
-				&movie,
-				nil,
-			)
-			if err != nil {
-				panic(err)
-			}
-			if resp.Status() != 200 {
-				panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-			}
-			if movie != nil {
-				cacheStore.Set(key, movie, cacheTime)
+		err = get("movie/" + movieId, napping.Params{
+			"append_to_response": "credits,images,alternative_titles,translations,external_ids,trailers",
+			"language": language,
+		}, &movie)
+		if err != nil {
+			return nil, err
+		}
+		if movie != nil {
+			for _, fallback := range LanguageFallbacks(language)[1:] {
+				if movie.Title != "" && movie.Overview != "" {
+					break
+				}
+				if other, _ := GetMovieById(movieId, fallback); other != nil {
+					if movie.Title == "" {
+						movie.Title = other.Title
+					}
+					if movie.Overview == "" {
+						movie.Overview = other.Overview
+					}
+				}
 			}
-		})
+			cacheStore.Set(key, movie, cacheTime)
+		}
 	}
 	if movie == nil {
-		return nil
+		return nil, &StatusError{Endpoint: "movie/" + movieId, Status: 404}
 	}
 	switch t := movie.RawPopularity.(type) {
 	case string:
@@ -64,9 +65,11 @@ func GetMovieById(movieId string, language string) *Movie {
 	case float64:
 		movie.Popularity = t
 	}
-	return movie
+	return movie, nil
 }
 
+// GetMovies fetches the movies concurrently, leaving nil the ones that
+// failed.
 func GetMovies(tmdbIds []int, language string) Movies {
 	var wg sync.WaitGroup
 	movies := make(Movies, len(tmdbIds))
@@ -74,87 +77,50 @@ func GetMovies(tmdbIds []int, language string) Movies {
 	for i, tmdbId := range tmdbIds {
 		go func(i int, tmdbId int) {
 			defer wg.Done()
-			movies[i] = GetMovie(tmdbId, language)
+			movie, err := GetMovie(tmdbId, language)
+			if err != nil {
+				tmdbLog.Errorf("Unable to get movie %d: %s", tmdbId, err)
+				return
+			}
+			movies[i] = movie
+			// ToListItem runs sequentially, get the artwork concurrently here
+			artwork.PrefetchMovie(tmdbId)
 		}(i, tmdbId)
 	}
 	wg.Wait()
 	return movies
 }
 
-func GetMovieGenres(language string) []*Genre {
+func GetMovieGenres(language string) ([]*Genre, error) {
 	genres := GenreList{}
-	rateLimiter.Call(func() {
-    urlValues := napping.Params{
-			"api_key": apiKey,
-			"language": language,
-		}.AsUrlValues()
-		resp, err := napping.Get(
-			tmdbEndpoint+"genre/movie/list",
-			&urlValues,
-			&genres,
-			nil,
-		)
-		if err != nil {
-			panic(err)
-		}
-		if resp.Status() != 200 {
-			panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-		}
-	})
-	return genres.Genres
+	if err := get("genre/movie/list", napping.Params{"language": language}, &genres); err != nil {
+		return nil, err
+	}
+	return genres.Genres, nil
 }
 
-func SearchMovies(query string, language string) Movies {
+func SearchMovies(query string, language string) (Movies, error) {
 	var results EntityList
-	rateLimiter.Call(func() {
-    urlValues := napping.Params{
-			"api_key": apiKey,
-			"query": query,
-		}.AsUrlValues()
-		resp, err := napping.Get(
-			tmdbEndpoint + "search/movie",
-			&urlValues,
-			&results,
-			nil,
-		)
-		if err != nil {
-			panic(err)
-		}
-		if resp.Status() != 200 {
-			panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-		}
-	})
+	if err := get("search/movie", napping.Params{"query": query}, &results); err != nil {
+		return nil, err
+	}
 	tmdbIds := make([]int, 0, len(results.Results))
 	for _, movie := range results.Results {
 		tmdbIds = append(tmdbIds, movie.Id)
 	}
-	return GetMovies(tmdbIds, language)
+	return GetMovies(tmdbIds, language), nil
 }
 
-func GetList(listId string, language string) Movies {
+func GetList(listId string, language string) (Movies, error) {
 	var results *List
-	rateLimiter.Call(func() {
-    urlValues := napping.Params{
-			"api_key": apiKey,
-		}.AsUrlValues()
-		resp, err := napping.Get(
-			tmdbEndpoint + "list/" + listId,
-			&urlValues,
-			&results,
-			nil,
-		)
-		if err != nil {
-			panic(err)
-		}
-		if resp.Status() != 200 {
-			panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-		}
-	})
+	if err := get("list/" + listId, nil, &results); err != nil {
+		return nil, err
+	}
 	tmdbIds := make([]int, 0, len(results.Items))
 	for _, movie := range results.Items {
 		tmdbIds = append(tmdbIds, movie.Id)
 	}
-	return GetMovies(tmdbIds, language)
+	return GetMovies(tmdbIds, language), nil
 }
 
 type ByPopularity Movies
@@ -163,15 +129,17 @@ func (a ByPopularity) Len() int           { return len(a) }
 func (a ByPopularity) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByPopularity) Less(i, j int) bool { return a[i].Popularity < a[j].Popularity }
 
-func ListMoviesComplete(endpoint string, params napping.Params, page int) Movies {
+// ListMoviesComplete fetches the pages concurrently, and fails if any of them
+// couldn't be fetched. Movies that fail on their own are left out.
+func ListMoviesComplete(endpoint string, params napping.Params, page int) (Movies, error) {
 	MaxPages := popularMoviesMaxPages
 	if page >= 0 {
 		MaxPages = 1
 	}
 	movies := make(Movies, MaxPages * moviesPerPage)
-	params["api_key"] = apiKey
 
 	wg := sync.WaitGroup{}
+	errs := make(chan error, MaxPages)
 	for i := 0; i < MaxPages; i++ {
 		wg.Add(1)
 		currentpage := i
@@ -188,31 +156,27 @@ func ListMoviesComplete(endpoint string, params napping.Params, page int) Movies
 			for k, v := range params {
 				tmpParams[k] = v
 			}
-      urlValues := tmpParams.AsUrlValues()
-			rateLimiter.Call(func() {
-				resp, err := napping.Get(
-					tmdbEndpoint + endpoint,
-					&urlValues,
-					&tmp,
-					nil,
-				)
-				if err != nil {
-					panic(err)
-				}
-				if resp.Status() != 200 {
-					panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-				}
-			})
+			if err := get(endpoint, tmpParams, &tmp); err != nil {
+				errs <- err
+				return
+			}
 			for i, movie := range tmp.Results {
-				movies[startMoviesIndex + i] = GetMovie(movie.Id, params["language"])
+				var err error
+				if movies[startMoviesIndex + i], err = GetMovie(movie.Id, params["language"]); err != nil {
+					tmdbLog.Warningf("Skipping movie %d of %s: %s", movie.Id, endpoint, err)
+				}
 			}
 		}(currentpage)
 	}
 	wg.Wait()
-	return movies
+	close(errs)
+	if len(errs) > 0 {
+		return nil, <-errs
+	}
+	return movies, nil
 }
 
-func PopularMoviesComplete(genre string, language string, page int) Movies {
+func PopularMoviesComplete(genre string, language string, page int) (Movies, error) {
 	var p napping.Params
 	if genre == "" {
 		p = napping.Params{
@@ -231,7 +195,7 @@ func PopularMoviesComplete(genre string, language string, page int) Movies {
 	return ListMoviesComplete("discover/movie", p, page)
 }
 
-func RecentMoviesComplete(genre string, language string, page int) Movies {
+func RecentMoviesComplete(genre string, language string, page int) (Movies, error) {
 	var p napping.Params
 	if genre == "" {
 		p = napping.Params{
@@ -252,11 +216,11 @@ func RecentMoviesComplete(genre string, language string, page int) Movies {
 	return ListMoviesComplete("discover/movie", p, page)
 }
 
-func TopRatedMoviesComplete(genre string, language string, page int) Movies {
+func TopRatedMoviesComplete(genre string, language string, page int) (Movies, error) {
 	return ListMoviesComplete("movie/top_rated", napping.Params{"language": language}, page)
 }
 
-func MostVotedMoviesComplete(genre string, language string, page int) Movies {
+func MostVotedMoviesComplete(genre string, language string, page int) (Movies, error) {
 	var p napping.Params
 	if genre == "" {
 		p = napping.Params{
@@ -304,6 +268,7 @@ func (movie *Movie) ToListItem() *xbmc.ListItem {
 			Poster: ImageURL(movie.PosterPath, "w500"),
 		},
 	}
+	artwork.Movie(movie.Id, config.Get().Language, item.Art)
 	item.Thumbnail = item.Art.Poster
 	item.Art.Thumbnail = item.Art.Poster
 	genres := make([]string, 0, len(movie.Genres))
@@ -319,10 +284,18 @@ func (movie *Movie) ToListItem() *xbmc.ListItem {
 		}
 	}
 
-	if item.Info.Trailer == "" && config.Get().Language != "en" {
-		enMovie := GetMovie(movie.Id, "en")
-		if enMovie.Trailers != nil {
-			for _, trailer := range enMovie.Trailers.Youtube {
+	// Trailers fall back down to english only, not to the original language
+	userLanguage := config.Get().Language
+	for _, fallback := range LanguageFallbacks(userLanguage)[1:] {
+		if item.Info.Trailer != "" || fallback == "" {
+			break
+		}
+		if fallback == userLanguage {
+			continue
+		}
+		otherMovie, _ := GetMovie(movie.Id, fallback)
+		if otherMovie != nil && otherMovie.Trailers != nil {
+			for _, trailer := range otherMovie.Trailers.Youtube {
 				item.Info.Trailer = trailer.Source
 				break
 			}
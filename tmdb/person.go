@@ -0,0 +1,180 @@
+package tmdb
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"math/rand"
+
+	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+type Person struct {
+	Id           int            `json:"id"`
+	Name         string         `json:"name"`
+	Biography    string         `json:"biography"`
+	Birthday     string         `json:"birthday"`
+	Deathday     string         `json:"deathday"`
+	PlaceOfBirth string         `json:"place_of_birth"`
+	ProfilePath  string         `json:"profile_path"`
+	Department   string         `json:"known_for_department"`
+	Popularity   float64        `json:"popularity"`
+	MovieCredits *PersonCredits `json:"movie_credits,omitempty"`
+	TVCredits    *PersonCredits `json:"tv_credits,omitempty"`
+}
+
+type PersonCredit struct {
+	Id         int     `json:"id"`
+	Character  string  `json:"character"`
+	Job        string  `json:"job"`
+	Popularity float64 `json:"popularity"`
+	VoteCount  int     `json:"vote_count"`
+}
+
+type PersonCredits struct {
+	Cast []*PersonCredit `json:"cast"`
+	Crew []*PersonCredit `json:"crew"`
+}
+
+type PersonList struct {
+	Results []*Person `json:"results"`
+}
+
+func GetPerson(personId int, language string) (*Person, error) {
+	var person *Person
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
+	key := fmt.Sprintf("com.tmdb.person.%d.%s", personId, language)
+	if err := cacheStore.Get(key, &person); err != nil {
+		err = get(fmt.Sprintf("person/%d", personId), napping.Params{
+			"append_to_response": "movie_credits,tv_credits",
+			"language": language,
+		}, &person)
+		if err != nil {
+			return nil, err
+		}
+		if person != nil {
+			cacheStore.Set(key, person, cacheTime)
+		}
+	}
+	if person == nil {
+		return nil, &StatusError{Endpoint: fmt.Sprintf("person/%d", personId), Status: 404}
+	}
+	return person, nil
+}
+
+func SearchPeople(query string, language string) ([]*Person, error) {
+	var results PersonList
+	if err := get("search/person", napping.Params{"query": query, "language": language}, &results); err != nil {
+		return nil, err
+	}
+	return results.Results, nil
+}
+
+// Ids returns the titles a person is credited for, best known first and
+// each only once when they're both cast and crew.
+func (credits *PersonCredits) Ids() []int {
+	if credits == nil {
+		return []int{}
+	}
+	seen := make(map[int]bool)
+	unique := make([]*PersonCredit, 0, len(credits.Cast) + len(credits.Crew))
+	for _, credit := range append(credits.Cast, credits.Crew...) {
+		if seen[credit.Id] {
+			continue
+		}
+		seen[credit.Id] = true
+		unique = append(unique, credit)
+	}
+	sort.Sort(sort.Reverse(CreditsByPopularity(unique)))
+
+	ids := make([]int, 0, len(unique))
+	for _, credit := range unique {
+		ids = append(ids, credit.Id)
+	}
+	return ids
+}
+
+func (person *Person) ToListItem() *xbmc.ListItem {
+	item := &xbmc.ListItem{
+		Label:  person.Name,
+		Label2: person.Department,
+		Info: &xbmc.ListItemInfo{
+			Count:       rand.Int(),
+			Title:       person.Name,
+			Plot:        person.Biography,
+			PlotOutline: person.Biography,
+			Date:        person.Birthday,
+		},
+		Art: &xbmc.ListItemArt{
+			Poster: ImageURL(person.ProfilePath, "h632"),
+		},
+	}
+	item.Thumbnail = item.Art.Poster
+	item.Art.Thumbnail = item.Art.Poster
+	return item
+}
+
+// CrewByPerson returns the crew members once each, with their jobs joined.
+func (credits *Credits) CrewByPerson() []*Crew {
+	crew := make([]*Crew, 0, len(credits.Crew))
+	byPerson := make(map[int]*Crew)
+	for _, member := range credits.Crew {
+		if merged, ok := byPerson[member.Id]; ok {
+			merged.Job += ", " + member.Job
+			continue
+		}
+		merged := *member
+		byPerson[member.Id] = &merged
+		crew = append(crew, &merged)
+	}
+	return crew
+}
+
+func (cast *Cast) ToListItem() *xbmc.ListItem {
+	return creditListItem(cast.Name, cast.Character, cast.ProfilePath)
+}
+
+func (crew *Crew) ToListItem() *xbmc.ListItem {
+	return creditListItem(crew.Name, crew.Job, crew.ProfilePath)
+}
+
+func creditListItem(name string, role string, profilePath string) *xbmc.ListItem {
+	label := name
+	if role != "" {
+		label = fmt.Sprintf("%s (%s)", name, role)
+	}
+	item := &xbmc.ListItem{
+		Label:  label,
+		Label2: role,
+		Info: &xbmc.ListItemInfo{
+			Count: rand.Int(),
+			Title: name,
+		},
+		Art: &xbmc.ListItemArt{
+			Poster: ImageURL(profilePath, "h632"),
+		},
+	}
+	item.Thumbnail = item.Art.Poster
+	item.Art.Thumbnail = item.Art.Poster
+	return item
+}
+
+type CreditsByPopularity []*PersonCredit
+
+func (a CreditsByPopularity) Len() int      { return len(a) }
+func (a CreditsByPopularity) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a CreditsByPopularity) Less(i, j int) bool {
+	if a[i].VoteCount != a[j].VoteCount {
+		return a[i].VoteCount < a[j].VoteCount
+	}
+	return a[i].Popularity < a[j].Popularity
+}
+
+type CastByOrder []*Cast
+
+func (a CastByOrder) Len() int           { return len(a) }
+func (a CastByOrder) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a CastByOrder) Less(i, j int) bool { return a[i].Order < a[j].Order }
@@ -4,62 +4,72 @@ import (
 	"fmt"
 	"path"
 	"time"
-	"errors"
 	"math/rand"
 
 	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/artwork"
 	"github.com/scakemyer/quasar/cache"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
-func GetSeason(showId int, seasonNumber int, language string) *Season {
+func GetSeason(showId int, seasonNumber int, language string) (*Season, error) {
 	var season *Season
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
 	key := fmt.Sprintf("com.tmdb.season.%d.%d.%s", showId, seasonNumber, language)
 	if err := cacheStore.Get(key, &season); err != nil {
-		rateLimiter.Call(func() {
-			urlValues := napping.Params{
-				"api_key": apiKey,
-				"append_to_response": "credits,images,videos,external_ids",
-				"language": language,
-			}.AsUrlValues()
-			resp, err := napping.Get(
-				fmt.Sprintf("%stv/%d/season/%d", tmdbEndpoint, showId, seasonNumber),
-				&urlValues,
-				&season,
-				nil,
-			)
-			if err != nil {
-				panic(err)
-			}
-			if resp.Status() != 200 {
-				panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-			}
-		})
+		endpoint := fmt.Sprintf("tv/%d/season/%d", showId, seasonNumber)
+		err = get(endpoint, napping.Params{
+			"append_to_response": "credits,images,videos,external_ids",
+			"language": language,
+		}, &season)
+		if err != nil {
+			return nil, err
+		}
+		if season == nil {
+			return nil, &StatusError{Endpoint: endpoint, Status: 404}
+		}
 		season.EpisodeCount = len(season.Episodes)
 
 		// Fix for shows that have translations but return empty strings
 		// for episode names and overviews.
-		// We detect if episodes have their name filled, and if not re-query
-		// with no language set.
+		// We fill them from the season in the fallback languages.
 		// See https://github.com/scakemyer/plugin.video.quasar/issues/249
-		if season.EpisodeCount > 0 {
-			for index := 0; index < season.EpisodeCount; index++ {
-				if season.Episodes[index].Name == "" {
-					season.Episodes[index] = GetEpisode(showId, seasonNumber, index + 1, "")
+		for _, fallback := range LanguageFallbacks(language)[1:] {
+			if season.Episodes.translated() {
+				break
+			}
+			other, _ := GetSeason(showId, seasonNumber, fallback)
+			if other == nil {
+				continue
+			}
+			for _, episode := range season.Episodes {
+				for _, otherEpisode := range other.Episodes {
+					if otherEpisode.EpisodeNumber != episode.EpisodeNumber {
+						continue
+					}
+					if episode.Name == "" {
+						episode.Name = otherEpisode.Name
+					}
+					if episode.Overview == "" {
+						episode.Overview = otherEpisode.Overview
+					}
 				}
 			}
 		}
 
-		if season != nil {
-			cacheStore.Set(key, season, cacheTime)
-		}
+		cacheStore.Set(key, season, cacheTime)
 	}
-	if season == nil {
-		return nil
+	return season, nil
+}
+
+func (episodes EpisodeList) translated() bool {
+	for _, episode := range episodes {
+		if episode.Name == "" || episode.Overview == "" {
+			return false
+		}
 	}
-	return season
+	return true
 }
 
 func (seasons SeasonList) ToListItems(show *Show) []*xbmc.ListItem {
@@ -70,6 +80,7 @@ func (seasons SeasonList) ToListItems(show *Show) []*xbmc.ListItem {
 		fanarts = append(fanarts, ImageURL(backdrop.FilePath, "w1280"))
 	}
 
+	showArt := show.artworkSources()
 	now := time.Now().UTC()
 	for _, season := range seasons {
 		if season.EpisodeCount == 0 {
@@ -80,7 +91,7 @@ func (seasons SeasonList) ToListItems(show *Show) []*xbmc.ListItem {
 			continue
 		}
 
-		item := season.ToListItem(show)
+		item := season.listItem(show, showArt)
 
 		if len(fanarts) > 0 {
 			item.Art.FanArt = fanarts[rand.Intn(len(fanarts))]
@@ -92,6 +103,10 @@ func (seasons SeasonList) ToListItems(show *Show) []*xbmc.ListItem {
 }
 
 func (season *Season) ToListItem(show *Show) *xbmc.ListItem {
+	return season.listItem(show, show.artworkSources())
+}
+
+func (season *Season) listItem(show *Show, showArt *artwork.ShowSources) *xbmc.ListItem {
 	name := fmt.Sprintf("Season %d", season.Season)
 	if season.Season == 0 {
 		name = "Specials"
@@ -122,8 +137,11 @@ func (season *Season) ToListItem(show *Show) *xbmc.ListItem {
 		item.Art.FanArt = fanarts[rand.Intn(len(fanarts))]
 	}
 
-	// FIXME
-	item.Info.Genre = show.Genres[0].Name
+	showArt.Season(season.Season, config.Get().Language, item.Art)
+
+	if len(show.Genres) > 0 {
+		item.Info.Genre = show.Genres[0].Name
+	}
 
 	return item
 }
@@ -5,47 +5,48 @@ import (
 	"path"
 	"sync"
 	"time"
-	"errors"
 	"strconv"
 	"strings"
 	"math/rand"
 
 	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/artwork"
 	"github.com/scakemyer/quasar/cache"
 	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
-func GetShow(showId int, language string) *Show {
+func GetShow(showId int, language string) (*Show, error) {
 	var show *Show
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
 	key := fmt.Sprintf("com.tmdb.show.%d.%s", showId, language)
 	if err := cacheStore.Get(key, &show); err != nil {
-		rateLimiter.Call(func() {
-      urlValues := napping.Params{
-				"api_key": apiKey,
-				"append_to_response": "credits,images,alternative_titles,translations,external_ids",
-				"language": language,
-			}.AsUrlValues()
-			resp, err := napping.Get(
-				tmdbEndpoint + "tv/" + strconv.Itoa(showId),
-				&urlValues,
-				&show,
-				nil,
-			)
-			if err != nil {
-				panic(err)
-			}
-			if resp.Status() != 200 {
-				panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-			}
-		})
+		err = get("tv/" + strconv.Itoa(showId), napping.Params{
+			"append_to_response": "credits,images,alternative_titles,translations,external_ids",
+			"language": language,
+		}, &show)
+		if err != nil {
+			return nil, err
+		}
 		if show != nil {
+			for _, fallback := range LanguageFallbacks(language)[1:] {
+				if show.Name != "" && show.Overview != "" {
+					break
+				}
+				if other, _ := GetShow(showId, fallback); other != nil {
+					if show.Name == "" {
+						show.Name = other.Name
+					}
+					if show.Overview == "" {
+						show.Overview = other.Overview
+					}
+				}
+			}
 			cacheStore.Set(key, show, cacheTime)
 		}
 	}
 	if show == nil {
-		return nil
+		return nil, &StatusError{Endpoint: "tv/" + strconv.Itoa(showId), Status: 404}
 	}
 	switch t := show.RawPopularity.(type) {
 	case string:
@@ -55,9 +56,19 @@ func GetShow(showId int, language string) *Show {
 	case float64:
 		show.Popularity = t
 	}
-	return show
+	return show, nil
+}
+
+// artworkSources fetches the artwork of the show once for its seasons and
+// episodes.
+func (show *Show) artworkSources() *artwork.ShowSources {
+	if show.ExternalIDs == nil {
+		return artwork.ForShow(0)
+	}
+	return artwork.ForShow(show.ExternalIDs.TVDBID)
 }
 
+// GetShows fetches the shows concurrently, leaving nil the ones that failed.
 func GetShows(showIds []int, language string) Shows {
 	var wg sync.WaitGroup
 	shows := make(Shows, len(showIds))
@@ -65,50 +76,44 @@ func GetShows(showIds []int, language string) Shows {
 	for i, showId := range showIds {
 		go func(i int, showId int) {
 			defer wg.Done()
-			shows[i] = GetShow(showId, language)
+			show, err := GetShow(showId, language)
+			if err != nil {
+				tmdbLog.Errorf("Unable to get show %d: %s", showId, err)
+				return
+			}
+			shows[i] = show
+			if show.ExternalIDs != nil {
+				artwork.PrefetchShow(show.ExternalIDs.TVDBID)
+			}
 		}(i, showId)
 	}
 	wg.Wait()
 	return shows
 }
 
-func SearchShows(query string, language string) Shows {
+func SearchShows(query string, language string) (Shows, error) {
 	var results EntityList
-	rateLimiter.Call(func() {
-		urlValues := napping.Params{
-			"api_key": apiKey,
-			"query": query,
-		}.AsUrlValues()
-		resp, err := napping.Get(
-			tmdbEndpoint + "search/tv",
-			&urlValues,
-			&results,
-			nil,
-		)
-		if err != nil {
-			panic(err)
-		}
-		if resp.Status() != 200 {
-			panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-		}
-	})
+	if err := get("search/tv", napping.Params{"query": query}, &results); err != nil {
+		return nil, err
+	}
 	tmdbIds := make([]int, 0, len(results.Results))
 	for _, entity := range results.Results {
 		tmdbIds = append(tmdbIds, entity.Id)
 	}
-	return GetShows(tmdbIds, language)
+	return GetShows(tmdbIds, language), nil
 }
 
-func ListShowsComplete(endpoint string, params napping.Params, page int) Shows {
+// ListShowsComplete fetches the pages concurrently, and fails if any of them
+// couldn't be fetched. Shows that fail on their own are left out.
+func ListShowsComplete(endpoint string, params napping.Params, page int) (Shows, error) {
 	MaxPages := popularMoviesMaxPages
 	if page >= 0 {
 		MaxPages = 1
 	}
 	shows := make(Shows, MaxPages * moviesPerPage)
 
-	params["api_key"] = apiKey
-
 	wg := sync.WaitGroup{}
+	errs := make(chan error, MaxPages)
 	for i := 0; i < MaxPages; i++ {
 		wg.Add(1)
 		currentpage := i
@@ -125,32 +130,28 @@ func ListShowsComplete(endpoint string, params napping.Params, page int) Shows {
 			for k, v := range params {
 				tmpParams[k] = v
 			}
-      urlValues := tmpParams.AsUrlValues()
-			rateLimiter.Call(func() {
-				resp, err := napping.Get(
-					tmdbEndpoint + endpoint,
-					&urlValues,
-					&tmp,
-					nil,
-				)
-				if err != nil {
-					panic(err)
-				}
-				if resp.Status() != 200 {
-					panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-				}
-			})
+			if err := get(endpoint, tmpParams, &tmp); err != nil {
+				errs <- err
+				return
+			}
 			for i, entity := range tmp.Results {
-				shows[startMoviesIndex + i] = GetShow(entity.Id, params["language"])
+				var err error
+				if shows[startMoviesIndex + i], err = GetShow(entity.Id, params["language"]); err != nil {
+					tmdbLog.Warningf("Skipping show %d of %s: %s", entity.Id, endpoint, err)
+				}
 			}
 		}(currentpage)
 	}
 	wg.Wait()
+	close(errs)
+	if len(errs) > 0 {
+		return nil, <-errs
+	}
 
-	return shows
+	return shows, nil
 }
 
-func PopularShowsComplete(genre string, language string, page int) Shows {
+func PopularShowsComplete(genre string, language string, page int) (Shows, error) {
 	var p napping.Params
 	if genre == "" {
 		p = napping.Params{
@@ -169,7 +170,7 @@ func PopularShowsComplete(genre string, language string, page int) Shows {
 	return ListShowsComplete("discover/tv", p, page)
 }
 
-func RecentShowsComplete(genre string, language string, page int) Shows {
+func RecentShowsComplete(genre string, language string, page int) (Shows, error) {
 	var p napping.Params
 	if genre == "" {
 		p = napping.Params{
@@ -188,7 +189,7 @@ func RecentShowsComplete(genre string, language string, page int) Shows {
 	return ListShowsComplete("discover/tv", p, page)
 }
 
-func RecentEpisodesComplete(genre string, language string, page int) Shows {
+func RecentEpisodesComplete(genre string, language string, page int) (Shows, error) {
 	var p napping.Params
 
 	if genre == "" {
@@ -208,11 +209,11 @@ func RecentEpisodesComplete(genre string, language string, page int) Shows {
 	return ListShowsComplete("discover/tv", p, page)
 }
 
-func TopRatedShowsComplete(genre string, language string, page int) Shows {
+func TopRatedShowsComplete(genre string, language string, page int) (Shows, error) {
 	return ListShowsComplete("tv/top_rated", napping.Params{"language": language}, page)
 }
 
-func MostVotedShowsComplete(genre string, language string, page int) Movies {
+func MostVotedShowsComplete(genre string, language string, page int) (Movies, error) {
 	return ListMoviesComplete("discover/tv", napping.Params{
 		"language":           language,
 		"sort_by":            "vote_count.desc",
@@ -221,27 +222,12 @@ func MostVotedShowsComplete(genre string, language string, page int) Movies {
 	}, page)
 }
 
-func GetTVGenres(language string) []*Genre {
+func GetTVGenres(language string) ([]*Genre, error) {
 	genres := GenreList{}
-	rateLimiter.Call(func() {
-    urlValues := napping.Params{
-			"api_key": apiKey,
-			"language": language,
-		}.AsUrlValues()
-		resp, err := napping.Get(
-			tmdbEndpoint + "genre/tv/list",
-			&urlValues,
-			&genres,
-			nil,
-		)
-		if err != nil {
-			panic(err)
-		}
-		if resp.Status() != 200 {
-			panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-		}
-	})
-	return genres.Genres
+	if err := get("genre/tv/list", napping.Params{"language": language}, &genres); err != nil {
+		return nil, err
+	}
+	return genres.Genres, nil
 }
 
 func (show *Show) ToListItem() *xbmc.ListItem {
@@ -273,6 +259,9 @@ func (show *Show) ToListItem() *xbmc.ListItem {
 			Poster: ImageURL(show.PosterPath, "w500"),
 		},
 	}
+	if show.ExternalIDs != nil {
+		artwork.Show(show.ExternalIDs.TVDBID, config.Get().Language, item.Art)
+	}
 	item.Thumbnail = item.Art.Poster
 	item.Art.Thumbnail = item.Art.Poster
 
@@ -5,8 +5,8 @@ import (
 	"sync"
 	"time"
 	"path"
-	"errors"
 	"strconv"
+	"strings"
 	"math/rand"
 
 	"github.com/op/go-logging"
@@ -223,7 +223,6 @@ type Trailer struct {
 }
 
 const (
-	tmdbEndpoint            = "http://api.themoviedb.org/3/"
 	imageEndpoint           = "http://image.tmdb.org/t/p/"
 	burstRate               = 40
 	burstTime               = 15 * time.Second
@@ -232,6 +231,8 @@ const (
 )
 
 var (
+	tmdbEndpoint = "http://api.themoviedb.org/3/"
+
 	apiKeys = []string{
 		"8cf43ad9c085135b9479ad5cf6bbcbda",
 		"ae4bd1b6fce2a5648671bfc171d15ba4",
@@ -252,10 +253,15 @@ func CheckApiKey() {
 
 	result := false
 	for index := len(apiKeys) - 1; index >= 0; index-- {
-		result = tmdbCheck(apiKey)
-		if result {
+		err := tmdbCheck(apiKey)
+		if err == nil {
+			result = true
 			tmdbLog.Noticef("TMDB API key check passed, using %s...", apiKey[:7])
 			break
+		} else if err != ErrInvalidAPIKey {
+			// Don't drop keys because TMDB is unreachable
+			tmdbLog.Warningf("Unable to check TMDB API key: %s", err)
+			return
 		} else {
 			tmdbLog.Warningf("TMDB API key failed: %s", apiKey)
 			if apiKey == apiKeys[index] {
@@ -269,40 +275,48 @@ func CheckApiKey() {
 	}
 }
 
-func tmdbCheck(key string) bool {
-	var result *Entity
-
-	urlValues := napping.Params{
-		"api_key": key,
-	}.AsUrlValues()
+// newSession routes napping requests through the shared outbound client.
+func newSession() *napping.Session {
+	return &napping.Session{Client: util.NewHTTPClient(0)}
+}
 
-	resp, err := napping.Get(
-		tmdbEndpoint + "movie/550",
-		&urlValues,
-		&result,
-		nil,
-	)
+func tmdbCheck(key string) error {
+	var result *Entity
+	return get("movie/550", napping.Params{"api_key": key}, &result)
+}
 
-	if err != nil {
-		panic(err)
+// LanguageFallbacks returns the languages to try in order when something isn't
+// translated, e.g. pt-BR, pt, en then no language at all for the original one,
+// which english users need too (see issue #249).
+func LanguageFallbacks(language string) []string {
+	languages := []string{language}
+	if language == "" {
+		return languages
 	}
-	if resp.Status() != 200 {
-		return false
+	if i := strings.Index(language, "-"); i > 0 {
+		languages = append(languages, language[:i])
 	}
-
-	return true
+	if languages[len(languages) - 1] != "en" {
+		languages = append(languages, "en")
+	}
+	return append(languages, "")
 }
 
 func ImageURL(uri string, size string) string {
+	if uri == "" {
+		return ""
+	}
 	return imageEndpoint + size + uri
 }
 
-func ListEntities(endpoint string, params napping.Params) []*Entity {
+func ListEntities(endpoint string, params napping.Params) ([]*Entity, error) {
 	var wg sync.WaitGroup
 	entities := make([]*Entity, popularMoviesMaxPages * moviesPerPage)
-	params["api_key"] = apiKey
-	params["language"] = "en"
+	if _, ok := params["language"]; !ok {
+		params["language"] = config.Get().Language
+	}
 
+	errs := make(chan error, popularMoviesMaxPages)
 	wg.Add(popularMoviesMaxPages)
 	for i := 0; i < popularMoviesMaxPages; i++ {
 		go func(page int) {
@@ -314,57 +328,35 @@ func ListEntities(endpoint string, params napping.Params) []*Entity {
 			for k, v := range params {
 				tmpParams[k] = v
 			}
-      urlValues := tmpParams.AsUrlValues()
-			rateLimiter.Call(func() {
-				resp, err := napping.Get(
-					tmdbEndpoint + endpoint,
-					&urlValues,
-					&tmp,
-					nil,
-				)
-				if err != nil {
-					panic(err)
-				}
-				if resp.Status() != 200 {
-					panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-				}
-			})
+			if err := get(endpoint, tmpParams, &tmp); err != nil {
+				errs <- err
+				return
+			}
 			for i, entity := range tmp.Results {
 				entities[page * moviesPerPage + i] = entity
 			}
 		}(i)
 	}
 	wg.Wait()
+	close(errs)
+	if len(errs) == popularMoviesMaxPages {
+		return nil, <-errs
+	}
 
-	return entities
+	return entities, nil
 }
 
-func Find(externalId string, externalSource string) *FindResult {
+func Find(externalId string, externalSource string) (*FindResult, error) {
 	var result *FindResult
 
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore := cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
 	key := fmt.Sprintf("com.tmdb.find.%s.%s", externalSource, externalId)
 	if err := cacheStore.Get(key, &result); err != nil {
-		rateLimiter.Call(func() {
-      urlValues := napping.Params{
-				"api_key": apiKey,
-				"external_source": externalSource,
-			}.AsUrlValues()
-			resp, err := napping.Get(
-				tmdbEndpoint + "find/" + externalId,
-				&urlValues,
-				&result,
-				nil,
-			)
-			if err != nil {
-				panic(err)
-			}
-			if resp.Status() != 200 {
-				panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
-			}
-			cacheStore.Set(key, result, 365 * 24 * time.Hour)
-		})
+		if err := get("find/" + externalId, napping.Params{"external_source": externalSource}, &result); err != nil {
+			return nil, err
+		}
+		cacheStore.Set(key, result, 365 * 24 * time.Hour)
 	}
 
-	return result
+	return result, nil
 }
@@ -0,0 +1,284 @@
+package trakt
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/jmcvetta/napping"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
+)
+
+const (
+	tokenFile   = "trakt_token.json"
+	redirectURI = "urn:ietf:wg:oauth:2.0:oob"
+	// Refresh a bit before Trakt expires the token
+	tokenRefreshMargin = 24 * time.Hour
+	// Added to the poll interval each time Trakt asks to slow down
+	slowDownInterval = 5 * time.Second
+)
+
+var (
+	ErrNotAuthorized = errors.New("No Trakt account linked")
+	ErrAuthExpired   = errors.New("Trakt device code expired")
+	ErrAuthDenied    = errors.New("Trakt authorization denied")
+	ErrAuthCancelled = errors.New("Trakt authorization cancelled")
+	errSlowDown      = errors.New("Trakt asked to poll slower")
+
+	authLog   = logging.MustGetLogger("trakt")
+	token     *Token
+	tokenLock = sync.Mutex{}
+)
+
+type Code struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+func (t *Token) Expires() time.Time {
+	return time.Unix(t.CreatedAt + t.ExpiresIn, 0)
+}
+
+func tokenPath() string {
+	return filepath.Join(config.Get().ProfilePath, tokenFile)
+}
+
+func loadToken() *Token {
+	data, err := ioutil.ReadFile(tokenPath())
+	if err != nil {
+		return nil
+	}
+	var t *Token
+	if err := json.Unmarshal(data, &t); err != nil || t == nil || t.AccessToken == "" {
+		authLog.Warningf("Ignoring invalid Trakt token in %s", tokenPath())
+		return nil
+	}
+	return t
+}
+
+func saveToken(t *Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tokenPath(), data, 0600)
+}
+
+// Authorized tells whether a Trakt account is linked.
+func Authorized() bool {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	if token == nil {
+		token = loadToken()
+	}
+	return token != nil
+}
+
+// currentToken returns the stored token, refreshing it when it's about to
+// expire or when force is set.
+func currentToken(force bool) (*Token, error) {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+
+	if token == nil {
+		token = loadToken()
+	}
+	if token == nil {
+		return nil, ErrNotAuthorized
+	}
+	if force || time.Now().Add(tokenRefreshMargin).After(token.Expires()) {
+		refreshed, err := refreshToken(token.RefreshToken)
+		if err == ErrNotAuthorized {
+			// Revoked, forget it so that we stop acting as linked
+			authLog.Warning("Trakt refused to refresh the token, unlinking the account")
+			token = nil
+			if err := os.Remove(tokenPath()); err != nil && os.IsNotExist(err) == false {
+				authLog.Errorf("Unable to remove Trakt token: %s", err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := saveToken(refreshed); err != nil {
+			authLog.Errorf("Unable to save Trakt token: %s", err)
+		}
+		token = refreshed
+	}
+	return token, nil
+}
+
+func refreshToken(refresh string) (*Token, error) {
+	header := newHeader()
+	var refreshed *Token
+	resp, err := send(&napping.Request{
+		Url: fmt.Sprintf("%s/oauth/token", ApiUrl),
+		Method: "POST",
+		Header: &header,
+		Payload: map[string]string{
+			"refresh_token": refresh,
+			"client_id":     ClientId,
+			"client_secret": ClientSecret,
+			"redirect_uri":  redirectURI,
+			"grant_type":    "refresh_token",
+		},
+		Result: &refreshed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status() {
+	case http.StatusOK:
+		authLog.Info("Refreshed Trakt token")
+		return refreshed, nil
+	case http.StatusUnauthorized, http.StatusBadRequest:
+		// The refresh token was revoked, the account needs linking again
+		return nil, ErrNotAuthorized
+	}
+	return nil, fmt.Errorf("Bad status refreshing Trakt token: %d", resp.Status())
+}
+
+// GetCode starts the device authorization, the user then enters
+// Code.UserCode at Code.VerificationURL.
+func GetCode() (*Code, error) {
+	header := newHeader()
+	var code *Code
+	resp, err := send(&napping.Request{
+		Url: fmt.Sprintf("%s/oauth/device/code", ApiUrl),
+		Method: "POST",
+		Header: &header,
+		Payload: map[string]string{
+			"client_id": ClientId,
+		},
+		Result: &code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status() != http.StatusOK || code == nil {
+		return nil, fmt.Errorf("Bad status getting Trakt device code: %d", resp.Status())
+	}
+	return code, nil
+}
+
+// pollToken returns a nil token while the user hasn't entered the code yet,
+// and errSlowDown when polling too often.
+func pollToken(code *Code) (*Token, error) {
+	header := newHeader()
+	var t *Token
+	resp, err := send(&napping.Request{
+		Url: fmt.Sprintf("%s/oauth/device/token", ApiUrl),
+		Method: "POST",
+		Header: &header,
+		Payload: map[string]string{
+			"code":          code.DeviceCode,
+			"client_id":     ClientId,
+			"client_secret": ClientSecret,
+		},
+		Result: &t,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status() {
+	case http.StatusOK:
+		return t, nil
+	case http.StatusBadRequest:
+		return nil, nil
+	case http.StatusTooManyRequests:
+		return nil, errSlowDown
+	case http.StatusGone:
+		return nil, ErrAuthExpired
+	case http.StatusTeapot:
+		return nil, ErrAuthDenied
+	}
+	return nil, fmt.Errorf("Bad status polling Trakt token: %d", resp.Status())
+}
+
+// Authorize polls until the user approved the code, then stores the token.
+// cancelled is checked between polls, e.g. for a dialog's cancel button.
+func Authorize(code *Code, cancelled func() bool) error {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		if cancelled() {
+			return ErrAuthCancelled
+		}
+		t, err := pollToken(code)
+		if err == errSlowDown {
+			interval += slowDownInterval
+			authLog.Infof("Polling Trakt every %s", interval)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if t == nil {
+			continue
+		}
+
+		tokenLock.Lock()
+		defer tokenLock.Unlock()
+		if err := saveToken(t); err != nil {
+			return err
+		}
+		token = t
+		authLog.Info("Trakt account linked")
+		return nil
+	}
+	return ErrAuthExpired
+}
+
+// Unlink revokes the token and forgets it.
+func Unlink() error {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+
+	if token == nil {
+		token = loadToken()
+	}
+	if token != nil {
+		header := newHeader()
+		header.Set("Authorization", "Bearer " + token.AccessToken)
+		if _, err := send(&napping.Request{
+			Url: fmt.Sprintf("%s/oauth/revoke", ApiUrl),
+			Method: "POST",
+			Header: &header,
+			Payload: map[string]string{
+				"token":         token.AccessToken,
+				"client_id":     ClientId,
+				"client_secret": ClientSecret,
+			},
+		}); err != nil {
+			authLog.Warningf("Unable to revoke Trakt token: %s", err)
+		}
+	}
+	token = nil
+	if err := os.Remove(tokenPath()); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	authLog.Info("Trakt account unlinked")
+	return nil
+}
@@ -0,0 +1,191 @@
+package trakt
+
+import (
+	"os"
+	"time"
+	"testing"
+	"io/ioutil"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+)
+
+// The token file is saved relative to the empty profile path
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "trakt")
+	if err != nil {
+		panic(err)
+	}
+	os.Chdir(dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func fakeTrakt(handler http.HandlerFunc) func() {
+	server := httptest.NewServer(handler)
+	previousUrl := ApiUrl
+	ApiUrl = server.URL
+	return func() {
+		ApiUrl = previousUrl
+		server.Close()
+	}
+}
+
+func setToken(t *Token) {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	token = t
+}
+
+func freshToken(accessToken string, refreshToken string) *Token {
+	return &Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64((90 * 24 * time.Hour).Seconds()),
+		CreatedAt:    time.Now().Unix(),
+	}
+}
+
+func TestPollToken(t *testing.T) {
+	statuses := map[int]error{
+		http.StatusGone:   ErrAuthExpired,
+		http.StatusTeapot: ErrAuthDenied,
+	}
+	for status, expected := range statuses {
+		done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+		if _, err := pollToken(&Code{DeviceCode: "device"}); err != expected {
+			t.Errorf("Expected %v for status %d, got %v", expected, status, err)
+		}
+		done()
+	}
+}
+
+func TestPollTokenSlowDown(t *testing.T) {
+	done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer done()
+
+	if _, err := pollToken(&Code{DeviceCode: "device"}); err != errSlowDown {
+		t.Errorf("Expected errSlowDown, got %v", err)
+	}
+}
+
+func TestAuthorizeSlowsDown(t *testing.T) {
+	polls := make([]time.Time, 0)
+	done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+		polls = append(polls, time.Now())
+		if len(polls) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(freshToken("access", "refresh"))
+	})
+	defer done()
+	defer setToken(nil)
+
+	code := &Code{DeviceCode: "device", Interval: 1, ExpiresIn: 60}
+	if err := Authorize(code, func() bool { return false }); err != nil {
+		t.Fatalf("Expected the account to be linked, got %s", err)
+	}
+	if len(polls) != 2 {
+		t.Fatalf("Expected 2 polls, got %d", len(polls))
+	}
+	if gap := polls[1].Sub(polls[0]); gap < time.Second + slowDownInterval {
+		t.Errorf("Expected the interval to grow after a slow down, polled again after %s", gap)
+	}
+}
+
+func TestPollTokenPending(t *testing.T) {
+	done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	defer done()
+
+	if pending, err := pollToken(&Code{DeviceCode: "device"}); pending != nil || err != nil {
+		t.Errorf("Expected no token and no error while pending, got %v, %v", pending, err)
+	}
+}
+
+func TestPollTokenSendsSecret(t *testing.T) {
+	done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["code"] != "device" || payload["client_id"] != ClientId || payload["client_secret"] == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(freshToken("access", "refresh"))
+	})
+	defer done()
+
+	linked, err := pollToken(&Code{DeviceCode: "device"})
+	if err != nil || linked == nil || linked.AccessToken != "access" {
+		t.Errorf("Expected the token, got %v, %v", linked, err)
+	}
+}
+
+func TestAuthorizedSendRefreshes(t *testing.T) {
+	refreshes := 0
+	done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			refreshes++
+			var payload map[string]string
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload["refresh_token"] != "refresh" || payload["grant_type"] != "refresh_token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(freshToken("renewed", "refresh2"))
+		case "/sync/history":
+			if r.Header.Get("Authorization") != "Bearer renewed" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("[]"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer done()
+	setToken(freshToken("revoked", "refresh"))
+	defer setToken(nil)
+
+	resp, err := GetWithAuth("sync/history", nil)
+	if err != nil {
+		t.Fatalf("Expected the request to succeed after a refresh, got %s", err)
+	}
+	if resp.Status() != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.Status())
+	}
+	if refreshes != 1 {
+		t.Errorf("Expected a single refresh, got %d", refreshes)
+	}
+	if saved := loadToken(); saved == nil || saved.AccessToken != "renewed" {
+		t.Errorf("Expected the renewed token to be saved, got %v", saved)
+	}
+}
+
+func TestAuthorizedSendRevokedRefresh(t *testing.T) {
+	done := fakeTrakt(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer done()
+	setToken(freshToken("revoked", "revoked"))
+	saveToken(freshToken("revoked", "revoked"))
+	defer setToken(nil)
+
+	if _, err := GetWithAuth("sync/history", nil); err != ErrNotAuthorized {
+		t.Errorf("Expected ErrNotAuthorized, got %v", err)
+	}
+	if Authorized() {
+		t.Errorf("Expected the account to be unlinked")
+	}
+	if _, err := os.Stat(tokenPath()); os.IsNotExist(err) == false {
+		t.Errorf("Expected the token file to be removed, got %v", err)
+	}
+}
@@ -28,7 +28,7 @@ func GetMovie(Id string) (movie *Movie) {
 	return movie
 }
 
-func SearchMovies(query string, page string) (movies []*Movies) {
+func SearchMovies(query string, page string) (movies []*Movies, pagination *Pagination) {
 	endPoint := "search"
 
 	params := napping.Params{
@@ -47,15 +47,11 @@ func SearchMovies(query string, page string) (movies []*Movies) {
 		panic(errors.New(fmt.Sprintf("Bad status: %d", resp.Status())))
 	}
 
-  // TODO use response headers for pagination limits:
-  // X-Pagination-Page-Count:10
-  // X-Pagination-Item-Count:100
-
 	resp.Unmarshal(&movies)
-	return movies
+	return movies, getPagination(resp)
 }
 
-func TopMovies(topCategory string, page string) (movies []*Movies) {
+func TopMovies(topCategory string, page string) (movies []*Movies, pagination *Pagination) {
 	endPoint := "movies/" + topCategory
 
 	params := napping.Params{
@@ -88,7 +84,7 @@ func TopMovies(topCategory string, page string) (movies []*Movies) {
 	} else {
 		resp.Unmarshal(&movies)
 	}
-	return movies
+	return movies, getPagination(resp)
 }
 
 func (movie *Movie) ToListItem() *xbmc.ListItem {
@@ -0,0 +1,243 @@
+package trakt
+
+import (
+	"os"
+	"sync"
+	"time"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
+)
+
+const (
+	scrobbleQueueFile = "trakt_scrobbles.json"
+	scrobbleQueueSize = 100
+	// Trakt marks an item as watched past this progress on stop
+	watchedProgress = 80
+
+	ScrobbleRetryInterval = 15 * time.Minute
+)
+
+var (
+	scrobbleLog       = logging.MustGetLogger("scrobble")
+	scrobbleQueueLock = sync.Mutex{}
+)
+
+type ScrobbleIDs struct {
+	TMDB int `json:"tmdb"`
+}
+
+type ScrobbleObject struct {
+	IDs *ScrobbleIDs `json:"ids"`
+}
+
+type ScrobbleEpisode struct {
+	Season int `json:"season"`
+	Number int `json:"number"`
+}
+
+// Scrobble is what's being played, as sent to the scrobble endpoints.
+type Scrobble struct {
+	Movie    *ScrobbleObject  `json:"movie,omitempty"`
+	Show     *ScrobbleObject  `json:"show,omitempty"`
+	Episode  *ScrobbleEpisode `json:"episode,omitempty"`
+	Progress float64          `json:"progress"`
+}
+
+type queuedScrobble struct {
+	Scrobble  *Scrobble `json:"scrobble"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+func MovieScrobble(tmdbId int) *Scrobble {
+	return &Scrobble{
+		Movie: &ScrobbleObject{IDs: &ScrobbleIDs{TMDB: tmdbId}},
+	}
+}
+
+func EpisodeScrobble(showId int, season int, episode int) *Scrobble {
+	return &Scrobble{
+		Show:    &ScrobbleObject{IDs: &ScrobbleIDs{TMDB: showId}},
+		Episode: &ScrobbleEpisode{Season: season, Number: episode},
+	}
+}
+
+// Send reports the playback state to Trakt, action being one of "start",
+// "pause" or "stop". Stops that can't reach Trakt are queued and retried
+// later, starts and pauses are only meaningful live so they're dropped.
+func (s *Scrobble) Send(action string, progress float64) error {
+	if Authorized() == false {
+		return ErrNotAuthorized
+	}
+	s.Progress = progress
+
+	err := postScrobble(action, s)
+	if err == nil {
+		go RetryScrobbles()
+		return nil
+	}
+	if action == "stop" && retryable(err) {
+		scrobbleLog.Warningf("Unable to scrobble, queuing it: %s", err)
+		queueScrobble(&queuedScrobble{
+			Scrobble:  s,
+			WatchedAt: time.Now().UTC(),
+		})
+	}
+	return err
+}
+
+type statusError int
+
+func (e statusError) Error() string {
+	return "Bad status from Trakt: " + http.StatusText(int(e))
+}
+
+func retryable(err error) bool {
+	if err == ErrNotAuthorized {
+		return false
+	}
+	if status, ok := err.(statusError); ok {
+		return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	}
+	// Network errors
+	return true
+}
+
+func postScrobble(action string, s *Scrobble) error {
+	resp, err := Post("scrobble/" + action, s)
+	if err != nil {
+		return err
+	}
+	switch resp.Status() {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		// Already scrobbled a moment ago
+		return nil
+	}
+	return statusError(resp.Status())
+}
+
+// replay sends a queued stop. A late scrobble would be dated now, so items
+// that were watched go to the history with the time they were played.
+func (q *queuedScrobble) replay() error {
+	s := q.Scrobble
+	if s.Progress < watchedProgress {
+		return postScrobble("pause", s)
+	}
+
+	var payload interface{}
+	if s.Movie != nil {
+		payload = map[string]interface{}{
+			"movies": []interface{}{
+				map[string]interface{}{
+					"watched_at": q.WatchedAt,
+					"ids":        s.Movie.IDs,
+				},
+			},
+		}
+	} else {
+		payload = syncEpisode(s.Show.IDs.TMDB, s.Episode.Season, s.Episode.Number, &q.WatchedAt)
+	}
+	resp, err := Post("sync/history", payload)
+	if err != nil {
+		return err
+	}
+	if resp.Status() != http.StatusCreated {
+		return statusError(resp.Status())
+	}
+	return nil
+}
+
+func scrobbleQueuePath() string {
+	return filepath.Join(config.Get().ProfilePath, scrobbleQueueFile)
+}
+
+func loadScrobbleQueue() []*queuedScrobble {
+	queue := make([]*queuedScrobble, 0)
+	data, err := ioutil.ReadFile(scrobbleQueuePath())
+	if err != nil {
+		return queue
+	}
+	if err := json.Unmarshal(data, &queue); err != nil {
+		scrobbleLog.Warningf("Ignoring invalid scrobble queue: %s", err)
+		return make([]*queuedScrobble, 0)
+	}
+	return queue
+}
+
+func saveScrobbleQueue(queue []*queuedScrobble) {
+	var err error
+	if len(queue) == 0 {
+		err = os.Remove(scrobbleQueuePath())
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		var data []byte
+		if data, err = json.Marshal(queue); err == nil {
+			err = ioutil.WriteFile(scrobbleQueuePath(), data, 0600)
+		}
+	}
+	if err != nil {
+		scrobbleLog.Errorf("Unable to save scrobble queue: %s", err)
+	}
+}
+
+func queueScrobble(q *queuedScrobble) {
+	scrobbleQueueLock.Lock()
+	defer scrobbleQueueLock.Unlock()
+
+	queue := append(loadScrobbleQueue(), q)
+	if len(queue) > scrobbleQueueSize {
+		queue = queue[len(queue) - scrobbleQueueSize:]
+	}
+	saveScrobbleQueue(queue)
+}
+
+// RetryScrobbles sends the queued scrobbles, keeping the ones that still
+// can't reach Trakt.
+func RetryScrobbles() {
+	scrobbleQueueLock.Lock()
+	defer scrobbleQueueLock.Unlock()
+
+	queue := loadScrobbleQueue()
+	if len(queue) == 0 || Authorized() == false {
+		return
+	}
+
+	sent := 0
+	pending := make([]*queuedScrobble, 0)
+	for i, q := range queue {
+		err := q.replay()
+		if err == nil {
+			sent++
+			continue
+		}
+		if retryable(err) == false {
+			scrobbleLog.Errorf("Dropping queued scrobble: %s", err)
+			continue
+		}
+		// Trakt is still unreachable, no point trying the rest now
+		pending = append(pending, queue[i:]...)
+		break
+	}
+	if sent > 0 {
+		scrobbleLog.Infof("Sent %d queued scrobbles", sent)
+	}
+	saveScrobbleQueue(pending)
+}
+
+// ScrobbleRetryLoop periodically retries the queued scrobbles.
+func ScrobbleRetryLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		RetryScrobbles()
+		<-ticker.C
+	}
+}
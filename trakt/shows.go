@@ -28,7 +28,7 @@ func GetShow(Id string) (show *Show) {
 	return show
 }
 
-func SearchShows(query string, page string) (shows []*Shows) {
+func SearchShows(query string, page string) (shows []*Shows, pagination *Pagination) {
 	endPoint := "search"
 
 	params := napping.Params{
@@ -48,10 +48,10 @@ func SearchShows(query string, page string) (shows []*Shows) {
 	}
 
 	resp.Unmarshal(&shows)
-	return shows
+	return shows, getPagination(resp)
 }
 
-func TopShows(topCategory string, page string) (shows []*Shows) {
+func TopShows(topCategory string, page string) (shows []*Shows, pagination *Pagination) {
 	endPoint := "shows/" + topCategory
 
 	params := napping.Params{
@@ -84,7 +84,7 @@ func TopShows(topCategory string, page string) (shows []*Shows) {
   } else {
   	resp.Unmarshal(&shows)
   }
-	return shows
+	return shows, getPagination(resp)
 }
 
 func (show *Show) ToListItem() *xbmc.ListItem {
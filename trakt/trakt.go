@@ -2,17 +2,23 @@ package trakt
 
 import (
 	"fmt"
+	"strconv"
 	"net/url"
 	"net/http"
 
 	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/util"
 )
 
 const (
-	ApiUrl      = "https://api-v2launch.trakt.tv"
-	ClientId    = "4407ab20a3a971e7c92d4996b36b76d0312ea085cb139d7c38a1a4c9f8428f60"
-	ApiVersion  = "2"
-	Limit       = "20"
+	ClientId     = "4407ab20a3a971e7c92d4996b36b76d0312ea085cb139d7c38a1a4c9f8428f60"
+	ClientSecret = "83f5993015942fe1320772c9c9886dce08252fa95445afab81a1603f8671e490"
+	ApiVersion   = "2"
+	Limit        = "20"
+)
+
+var (
+	ApiUrl = "https://api-v2launch.trakt.tv"
 )
 
 type Object struct {
@@ -137,12 +143,49 @@ type IDs struct {
   Slug   string `json:"slug"`
 }
 
-func Get(endPoint string, params url.Values) (resp *napping.Response, err error) {
-	header := http.Header{
+// Pagination is the position of a page in a paginated list, as told by the
+// X-Pagination-* headers.
+type Pagination struct {
+	Page      int `json:"page"`
+	Limit     int `json:"limit"`
+	PageCount int `json:"page_count"`
+	ItemCount int `json:"item_count"`
+}
+
+// getPagination returns nil when the endpoint isn't paginated.
+func getPagination(resp *napping.Response) *Pagination {
+	header := resp.HttpResponse().Header
+	if header.Get("X-Pagination-Page") == "" {
+		return nil
+	}
+	pagination := &Pagination{}
+	pagination.Page, _ = strconv.Atoi(header.Get("X-Pagination-Page"))
+	pagination.Limit, _ = strconv.Atoi(header.Get("X-Pagination-Limit"))
+	pagination.PageCount, _ = strconv.Atoi(header.Get("X-Pagination-Page-Count"))
+	pagination.ItemCount, _ = strconv.Atoi(header.Get("X-Pagination-Item-Count"))
+	return pagination
+}
+
+// HasNext tells whether there's a page after this one.
+func (p *Pagination) HasNext() bool {
+	return p != nil && p.Page < p.PageCount
+}
+
+func newHeader() http.Header {
+	return http.Header{
 		"Content-type": []string{"application/json"},
 		"trakt-api-key": []string{ClientId},
 		"trakt-api-version": []string{ApiVersion},
 	}
+}
+
+func send(req *napping.Request) (*napping.Response, error) {
+	session := napping.Session{Client: util.NewHTTPClient(0)}
+	return session.Send(req)
+}
+
+func Get(endPoint string, params url.Values) (resp *napping.Response, err error) {
+	header := newHeader()
 
 	req := napping.Request{
 		Url: fmt.Sprintf("%s/%s", ApiUrl, endPoint),
@@ -151,5 +194,49 @@ func Get(endPoint string, params url.Values) (resp *napping.Response, err error)
 		Header: &header,
 	}
 
-	return napping.Send(&req)
+	return send(&req)
+}
+
+// authorizedSend sends the request on behalf of the linked account,
+// refreshing the token once if Trakt rejects it.
+func authorizedSend(req *napping.Request) (*napping.Response, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		token, err := currentToken(attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		header := newHeader()
+		header.Set("Authorization", "Bearer " + token.AccessToken)
+		req.Header = &header
+
+		resp, err := send(req)
+		if err != nil || resp.Status() != http.StatusUnauthorized {
+			return resp, err
+		}
+	}
+	return nil, ErrNotAuthorized
+}
+
+func GetWithAuth(endPoint string, params url.Values) (resp *napping.Response, err error) {
+	return authorizedSend(&napping.Request{
+		Url: fmt.Sprintf("%s/%s", ApiUrl, endPoint),
+		Method: "GET",
+		Params: &params,
+	})
+}
+
+func Post(endPoint string, payload interface{}) (resp *napping.Response, err error) {
+	return authorizedSend(&napping.Request{
+		Url: fmt.Sprintf("%s/%s", ApiUrl, endPoint),
+		Method: "POST",
+		Payload: payload,
+	})
+}
+
+func Delete(endPoint string, params url.Values) (resp *napping.Response, err error) {
+	return authorizedSend(&napping.Request{
+		Url: fmt.Sprintf("%s/%s", ApiUrl, endPoint),
+		Method: "DELETE",
+		Params: &params,
+	})
 }
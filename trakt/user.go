@@ -0,0 +1,251 @@
+package trakt
+
+import (
+	"fmt"
+	"time"
+	"net/url"
+	"net/http"
+
+	"github.com/jmcvetta/napping"
+)
+
+type List struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ItemCount   int    `json:"item_count"`
+	IDs         *IDs   `json:"ids"`
+}
+
+type CalendarShow struct {
+	FirstAired string   `json:"first_aired"`
+	Episode    *Episode `json:"episode"`
+	Show       *Show    `json:"show"`
+}
+
+type WatchedMovie struct {
+	Plays int    `json:"plays"`
+	Movie *Movie `json:"movie"`
+}
+
+type WatchedShow struct {
+	Plays   int              `json:"plays"`
+	Show    *Show            `json:"show"`
+	Seasons []*WatchedSeason `json:"seasons"`
+}
+
+type WatchedSeason struct {
+	Number   int               `json:"number"`
+	Episodes []*WatchedEpisode `json:"episodes"`
+}
+
+type WatchedEpisode struct {
+	Number int `json:"number"`
+	Plays  int `json:"plays"`
+}
+
+// getUser fetches one of the linked account's endpoints.
+func getUser(endPoint string, params url.Values, result interface{}) error {
+	_, err := getUserPage(endPoint, params, result)
+	return err
+}
+
+func getUserPage(endPoint string, params url.Values, result interface{}) (*Pagination, error) {
+	resp, err := GetWithAuth(endPoint, params)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status() != http.StatusOK {
+		return nil, fmt.Errorf("Bad status getting %s: %d", endPoint, resp.Status())
+	}
+	return getPagination(resp), resp.Unmarshal(result)
+}
+
+func userParams(page string) url.Values {
+	params := napping.Params{
+		"extended": "full,images",
+	}
+	if page != "" {
+		params["page"] = page
+		params["limit"] = Limit
+	}
+	return params.AsUrlValues()
+}
+
+func WatchlistMovies() (movies []*Movies, err error) {
+	err = getUser("sync/watchlist/movies", userParams(""), &movies)
+	return
+}
+
+func CollectionMovies() (movies []*Movies, err error) {
+	err = getUser("sync/collection/movies", userParams(""), &movies)
+	return
+}
+
+func HistoryMovies(page string) (movies []*Movies, pagination *Pagination, err error) {
+	pagination, err = getUserPage("sync/history/movies", userParams(page), &movies)
+	return
+}
+
+func RecommendedMovies() ([]*Movies, error) {
+	var movieList []*Movie
+	if err := getUser("recommendations/movies", userParams(""), &movieList); err != nil {
+		return nil, err
+	}
+	movies := make([]*Movies, 0, len(movieList))
+	for _, movie := range movieList {
+		movies = append(movies, &Movies{Movie: movie})
+	}
+	return movies, nil
+}
+
+func ListMovies(listId string) (movies []*Movies, err error) {
+	err = getUser(fmt.Sprintf("users/me/lists/%s/items/movies", listId), userParams(""), &movies)
+	return
+}
+
+func WatchlistShows() (shows []*Shows, err error) {
+	err = getUser("sync/watchlist/shows", userParams(""), &shows)
+	return
+}
+
+func CollectionShows() (shows []*Shows, err error) {
+	err = getUser("sync/collection/shows", userParams(""), &shows)
+	return
+}
+
+func HistoryShows(page string) (shows []*Shows, pagination *Pagination, err error) {
+	pagination, err = getUserPage("sync/history/shows", userParams(page), &shows)
+	return
+}
+
+func RecommendedShows() ([]*Shows, error) {
+	var showList []*Show
+	if err := getUser("recommendations/shows", userParams(""), &showList); err != nil {
+		return nil, err
+	}
+	shows := make([]*Shows, 0, len(showList))
+	for _, show := range showList {
+		shows = append(shows, &Shows{Show: show})
+	}
+	return shows, nil
+}
+
+func ListShows(listId string) (shows []*Shows, err error) {
+	err = getUser(fmt.Sprintf("users/me/lists/%s/items/shows", listId), userParams(""), &shows)
+	return
+}
+
+func Lists() (lists []*List, err error) {
+	err = getUser("users/me/lists", nil, &lists)
+	return
+}
+
+// CalendarShows returns the episodes of the account's shows airing this
+// week, starting on Monday.
+func CalendarShows() (calendar []*CalendarShow, err error) {
+	now := time.Now()
+	start := now.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+	endPoint := fmt.Sprintf("calendars/my/shows/%s/7", start.Format("2006-01-02"))
+	err = getUser(endPoint, userParams(""), &calendar)
+	return
+}
+
+func WatchedMovies() (movies []*WatchedMovie, err error) {
+	err = getUser("sync/watched/movies", nil, &movies)
+	return
+}
+
+func WatchedShows() (shows []*WatchedShow, err error) {
+	err = getUser("sync/watched/shows", nil, &shows)
+	return
+}
+
+// syncItems builds the payload the sync endpoints take, itemType being
+// "movies" or "shows".
+func syncItems(itemType string, tmdbIds ...int) map[string]interface{} {
+	items := make([]interface{}, 0, len(tmdbIds))
+	for _, tmdbId := range tmdbIds {
+		items = append(items, map[string]interface{}{
+			"ids": &ScrobbleIDs{TMDB: tmdbId},
+		})
+	}
+	return map[string]interface{}{
+		itemType: items,
+	}
+}
+
+func postSync(endPoint string, itemType string, tmdbIds ...int) error {
+	resp, err := Post(endPoint, syncItems(itemType, tmdbIds...))
+	if err != nil {
+		return err
+	}
+	if resp.Status() != http.StatusOK && resp.Status() != http.StatusCreated {
+		return fmt.Errorf("Bad status posting to %s: %d", endPoint, resp.Status())
+	}
+	return nil
+}
+
+// syncEpisode builds the sync payload of a single episode, dated watchedAt
+// unless it's nil.
+func syncEpisode(showId int, season int, number int, watchedAt *time.Time) map[string]interface{} {
+	episode := map[string]interface{}{
+		"number": number,
+	}
+	if watchedAt != nil {
+		episode["watched_at"] = watchedAt
+	}
+	return map[string]interface{}{
+		"shows": []interface{}{
+			map[string]interface{}{
+				"ids": &ScrobbleIDs{TMDB: showId},
+				"seasons": []interface{}{
+					map[string]interface{}{
+						"number":   season,
+						"episodes": []interface{}{episode},
+					},
+				},
+			},
+		},
+	}
+}
+
+func postEpisodeSync(endPoint string, showId int, season int, episode int) error {
+	resp, err := Post(endPoint, syncEpisode(showId, season, episode, nil))
+	if err != nil {
+		return err
+	}
+	if resp.Status() != http.StatusOK && resp.Status() != http.StatusCreated {
+		return fmt.Errorf("Bad status posting to %s: %d", endPoint, resp.Status())
+	}
+	return nil
+}
+
+func AddToWatchlist(itemType string, tmdbId int) error {
+	return postSync("sync/watchlist", itemType, tmdbId)
+}
+
+func RemoveFromWatchlist(itemType string, tmdbId int) error {
+	return postSync("sync/watchlist/remove", itemType, tmdbId)
+}
+
+// MarkWatched adds the item to the history, for shows that's every aired
+// episode.
+func MarkWatched(itemType string, tmdbId int) error {
+	return postSync("sync/history", itemType, tmdbId)
+}
+
+func MarkUnwatched(itemType string, tmdbId int) error {
+	return postSync("sync/history/remove", itemType, tmdbId)
+}
+
+func MarkEpisodeWatched(showId int, season int, episode int) error {
+	return postEpisodeSync("sync/history", showId, season, episode)
+}
+
+func MarkEpisodeUnwatched(showId int, season int, episode int) error {
+	return postEpisodeSync("sync/history/remove", showId, season, episode)
+}
+
+func AddToCollection(itemType string, tmdbIds ...int) error {
+	return postSync("sync/collection", itemType, tmdbIds...)
+}
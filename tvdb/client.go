@@ -0,0 +1,182 @@
+package tvdb
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+	"errors"
+	"net/http"
+
+	"github.com/op/go-logging"
+	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/util"
+)
+
+const (
+	maxRetries     = 3
+	tokenKey       = "com.tvdb.token"
+	// Tokens are valid for a month
+	tokenCacheTime = 25 * 24 * time.Hour
+)
+
+var (
+	tvdbLog = logging.MustGetLogger("tvdb")
+
+	ErrNoAPIKey      = errors.New("No TVDB v4 API key set")
+	ErrInvalidAPIKey = errors.New("TVDB API key is invalid")
+
+	rateLimiter = util.NewRateLimiter(burstRate, burstTime, simultaneousConnections)
+	tokenLock   = sync.Mutex{}
+
+	retryBaseDelay = 1 * time.Second
+)
+
+// StatusError is returned when TVDB answers with an unexpected status.
+type StatusError struct {
+	Endpoint string
+	Status   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("TVDB returned status %d for %s", e.Status, e.Endpoint)
+}
+
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.Status == http.StatusNotFound
+}
+
+// response is the envelope of every v4 answer.
+type response struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data"`
+	Links  *struct {
+		Next *string `json:"next"`
+	} `json:"links"`
+}
+
+func newSession() *napping.Session {
+	return &napping.Session{Client: util.NewHTTPClient(0)}
+}
+
+func cacheStore() cache.CacheStore {
+	return cache.SharedStore(path.Join(config.Get().ProfilePath, "cache"))
+}
+
+// login needs a v4 project key, and the PIN of the user's subscription when
+// it's a user-supported key.
+func login() (string, error) {
+	apiKey := config.Get().TVDBApiKey
+	if apiKey == "" {
+		return "", ErrNoAPIKey
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	payload := map[string]string{
+		"apikey": apiKey,
+	}
+	if pin := config.Get().TVDBPin; pin != "" {
+		payload["pin"] = pin
+	}
+	result := &response{Data: &token}
+	var resp *napping.Response
+	var err error
+	rateLimiter.Call(func() {
+		resp, err = newSession().Post(tvdbEndpoint + "/login", &payload, result, nil)
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Status() == http.StatusUnauthorized {
+		return "", ErrInvalidAPIKey
+	} else if resp.Status() != http.StatusOK {
+		return "", &StatusError{Endpoint: "login", Status: resp.Status()}
+	}
+	return token.Token, nil
+}
+
+// getToken returns the cached token, logging in again when there's none or
+// when renew is set because TVDB rejected it.
+func getToken(renew bool) (string, error) {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+
+	var token string
+	store := cacheStore()
+	if renew == false {
+		if err := store.Get(tokenKey, &token); err == nil && token != "" {
+			return token, nil
+		}
+	}
+	token, err := login()
+	if err != nil {
+		return "", err
+	}
+	store.Set(tokenKey, token, tokenCacheTime)
+	return token, nil
+}
+
+// get calls a TVDB endpoint with the token, unwrapping the data of the
+// answer into result. Server errors and rate limiting are retried, and an
+// expired token is renewed once.
+func get(endpoint string, params napping.Params, result interface{}) (next bool, err error) {
+	if params == nil {
+		params = napping.Params{}
+	}
+	urlValues := params.AsUrlValues()
+
+	renew := false
+	renewed := false
+	delay := retryBaseDelay
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			tvdbLog.Warningf("Retrying %s in %s: %s", endpoint, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		var token string
+		if token, err = getToken(renew); err != nil {
+			return false, err
+		}
+		renew = false
+		header := http.Header{
+			"Authorization": []string{"Bearer " + token},
+			"Accept":        []string{"application/json"},
+		}
+		envelope := &response{Data: result}
+
+		var resp *napping.Response
+		rateLimiter.Call(func() {
+			resp, err = newSession().Send(&napping.Request{
+				Url:    tvdbEndpoint + "/" + endpoint,
+				Method: "GET",
+				Params: &urlValues,
+				Header: &header,
+				Result: envelope,
+			})
+		})
+		if err != nil {
+			continue
+		}
+
+		switch status := resp.Status(); {
+		case status == http.StatusOK:
+			return envelope.Links != nil && envelope.Links.Next != nil && *envelope.Links.Next != "", nil
+		case status == http.StatusUnauthorized && renewed == false:
+			renew = true
+			renewed = true
+			err = &StatusError{Endpoint: endpoint, Status: status}
+		case status == http.StatusTooManyRequests || status >= 500:
+			err = &StatusError{Endpoint: endpoint, Status: status}
+		default:
+			return false, &StatusError{Endpoint: endpoint, Status: status}
+		}
+	}
+	tvdbLog.Errorf("Giving up on %s: %s", endpoint, err)
+	return false, err
+}
@@ -2,87 +2,85 @@ package tvdb
 
 import (
 	"fmt"
-	"path"
 	"sort"
 	"time"
-	"bytes"
 	"strconv"
-	"net/http"
-	"io/ioutil"
-	"archive/zip"
-	"encoding/xml"
+	"strings"
 
-	"github.com/scakemyer/quasar/cache"
-	"github.com/scakemyer/quasar/config"
+	"github.com/jmcvetta/napping"
+)
+
+var (
+	tvdbEndpoint = "https://api4.thetvdb.com/v4"
 )
 
 const (
-	tvdbUrl                 = "http://thetvdb.com"
-	tvdbEndpoint            = tvdbUrl + "/api"
-	apiKey                  = "1D62F2F90030C444"
+	artworksUrl             = "https://artworks.thetvdb.com"
 	burstRate               = 30
 	burstTime               = 1 * time.Second
 	simultaneousConnections = 20
 	cacheTime               = 2 * time.Hour
+	artworkCacheTime        = 24 * time.Hour
 )
 
 type SeasonList []*Season
 type EpisodeList []*Episode
 
 type Episode struct {
-	Id            string `xml:"id"`
-	Director      string `xml:"Director"`
-	EpisodeName   string `xml:"EpisodeName"`
-	EpisodeNumber int    `xml:"EpisodeNumber"`
-	FirstAired    string `xml:"FirstAired"`
-	GuestStars    string `xml:"GuestStars"`
-	ImdbId        string `xml:"IMDB_ID"`
-	Language      string `xml:"Language"`
-	Overview      string `xml:"Overview"`
-	Rating        string `xml:"Rating"`
-	RatingCount   string `xml:"RatingCount"`
-	SeasonNumber  int    `xml:"SeasonNumber"`
-	Writer        string `xml:"Writer"`
-	FileName      string `xml:"filename"`
-	LastUpdated   string `xml:"lastupdated"`
-	SeasonId      string `xml:"seasonid"`
-	SeriesId      string `xml:"seriesid"`
-	ThumbHeight   string `xml:"thumb_height"`
-	ThumbWidth    string `xml:"thumb_width"`
-
-	AbsoluteNumber       int    `xml:"-"`
-	AbsoluteNumberString string `xml:"absolute_number"`
+	Id            string
+	Director      string
+	EpisodeName   string
+	EpisodeNumber int
+	FirstAired    string
+	GuestStars    string
+	ImdbId        string
+	Language      string
+	Overview      string
+	Rating        string
+	RatingCount   string
+	SeasonNumber  int
+	Writer        string
+	FileName      string
+	LastUpdated   string
+	SeasonId      string
+	SeriesId      string
+	ThumbHeight   string
+	ThumbWidth    string
+
+	AbsoluteNumber int
+	DVDSeason      int
+	DVDEpisode     int
 }
 
 type Show struct {
-	Id            int    `xml:"id"`
-	ActorsSimple  string `xml:"Actors"`
-	AirsDayOfWeek string `xml:"Airs_DayOfWeek"`
-	AirsTime      string `xml:"Airs_Time"`
-	ContentRating string `xml:"ContentRating"`
-	FirstAired    string `xml:"FirstAired"`
-	Genre         string `xml:"Genre"`
-	ImdbId        string `xml:"IMDB_ID"`
-	Language      string `xml:"Language"`
-	Network       string `xml:"Network"`
-	NetworkId     string `xml:"NetworkID"`
-	Overview      string `xml:"Overview"`
-	Rating        string `xml:"Rating"`
-	RatingCount   string `xml:"RatingCount"`
-	RuntimeString string `xml:"Runtime"`
-	SeriesID      string `xml:"SeriesID"`
-	SeriesName    string `xml:"SeriesName"`
-	Status        string `xml:"Status"`
-	Banner        string `xml:"banner"`
-	FanArt        string `xml:"fanart"`
-	LastUpdated   int    `xml:"lastupdated"`
-	Poster        string `xml:"poster"`
-
-	Runtime int `xml:"-"`
-
-	Seasons SeasonList `xml:"-"`
-	Banners []*Banner  `xml:"-"`
-	Actors  []*Actor   `xml:"-"`
+	Id            int
+	ActorsSimple  string
+	AirsDayOfWeek string
+	AirsTime      string
+	ContentRating string
+	FirstAired    string
+	Genre         string
+	ImdbId        string
+	Language      string
+	Network       string
+	NetworkId     string
+	Overview      string
+	Rating        string
+	RatingCount   string
+	RuntimeString string
+	SeriesID      string
+	SeriesName    string
+	Status        string
+	Banner        string
+	FanArt        string
+	LastUpdated   int
+	Poster        string
+
+	Runtime int
+
+	Seasons SeasonList
+	Banners []*Banner
+	Actors  []*Actor
 }
 
 type Season struct {
@@ -91,92 +89,381 @@ type Season struct {
 }
 
 type Banner struct {
-	Id            string `xml:"id"`
-	BannerPath    string `xml:"BannerPath"`
-	BannerType    string `xml:"BannerType"`
-	BannerType2   string `xml:"BannerType2"`
-	Colors        string `xml:"Colors"`
-	Language      string `xml:"Language"`
-	Rating        string `xml:"Rating"`
-	RatingCount   int    `xml:"RatingCount"`
-	SeriesName    string `xml:"SeriesName"`
-	ThumbnailPath string `xml:"ThumbnailPath"`
-	VignettePath  string `xml:"VignettePath"`
-	Season        int    `xml:"Season,omitempty"`
+	Id            string
+	BannerPath    string
+	BannerType    string
+	BannerType2   string
+	Colors        string
+	Language      string
+	Rating        string
+	RatingCount   int
+	SeriesName    string
+	ThumbnailPath string
+	VignettePath  string
+	Season        int
 }
 
 type Actor struct {
-	Id        string `xml:"id"`
-	Image     string `xml:"Image"`
-	Name      string `xml:"Name"`
-	Role      string `xml:"Role"`
-	SortOrder int    `xml:"SortOrder"`
+	Id        string
+	Image     string
+	Name      string
+	Role      string
+	SortOrder int
 }
 
-func NewShow(tvdbId string, language string) (*Show, error) {
-	var serie struct {
-		Serie    *Show      `xml:"Series"`
-		Episodes []*Episode `xml:"Episode"`
+type seriesRecord struct {
+	Id               int             `json:"id"`
+	Name             string          `json:"name"`
+	Image            string          `json:"image"`
+	FirstAired       string          `json:"firstAired"`
+	LastUpdated      string          `json:"lastUpdated"`
+	Score            float64         `json:"score"`
+	OriginalLanguage string          `json:"originalLanguage"`
+	AverageRuntime   int             `json:"averageRuntime"`
+	Overview         string          `json:"overview"`
+	AirsTime         string          `json:"airsTime"`
+	AirsDays         map[string]bool `json:"airsDays"`
+	Status           *struct {
+		Name string `json:"name"`
+	} `json:"status"`
+	Genres []*struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	LatestNetwork *struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"latestNetwork"`
+	RemoteIds []*struct {
+		Id         string `json:"id"`
+		SourceName string `json:"sourceName"`
+	} `json:"remoteIds"`
+	ContentRatings []*struct {
+		Name    string `json:"name"`
+		Country string `json:"country"`
+	} `json:"contentRatings"`
+	Characters []*struct {
+		Id           int    `json:"id"`
+		Name         string `json:"name"`
+		PersonName   string `json:"personName"`
+		Image        string `json:"image"`
+		PersonImgURL string `json:"personImgURL"`
+		PeopleType   string `json:"peopleType"`
+		Sort         int    `json:"sort"`
+	} `json:"characters"`
+	Seasons []*struct {
+		Id     int `json:"id"`
+		Number int `json:"number"`
+	} `json:"seasons"`
+}
+
+type translationRecord struct {
+	Name     string `json:"name"`
+	Overview string `json:"overview"`
+	Language string `json:"language"`
+}
+
+type artworkRecord struct {
+	Id        int     `json:"id"`
+	Image     string  `json:"image"`
+	Thumbnail string  `json:"thumbnail"`
+	Language  string  `json:"language"`
+	Type      int     `json:"type"`
+	Score     float64 `json:"score"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	SeasonId  int     `json:"seasonId"`
+}
+
+type episodeRecord struct {
+	Id             int    `json:"id"`
+	SeriesId       int    `json:"seriesId"`
+	Name           string `json:"name"`
+	Aired          string `json:"aired"`
+	Overview       string `json:"overview"`
+	Image          string `json:"image"`
+	Number         int    `json:"number"`
+	SeasonNumber   int    `json:"seasonNumber"`
+	AbsoluteNumber int    `json:"absoluteNumber"`
+	LastUpdated    string `json:"lastUpdated"`
+}
+
+// Artwork types, as listed by the artwork/types endpoint
+var artworkTypes = map[int][]string{
+	1:  []string{"series", "graphical"},
+	2:  []string{"poster", "680x1000"},
+	3:  []string{"fanart", ""},
+	5:  []string{"icon", ""},
+	6:  []string{"season", "seasonwide"},
+	7:  []string{"season", "season"},
+	8:  []string{"seasonfanart", ""},
+	22: []string{"clearart", ""},
+	23: []string{"clearlogo", ""},
+}
+
+var weekDays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// TVDB uses three letter language codes
+var languageCodes = map[string]string{
+	"ar": "ara", "bg": "bul", "cs": "ces", "da": "dan", "de": "deu",
+	"el": "ell", "en": "eng", "es": "spa", "fi": "fin", "fr": "fra",
+	"he": "heb", "hr": "hrv", "hu": "hun", "it": "ita", "ja": "jpn",
+	"ko": "kor", "nl": "nld", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "ron", "ru": "rus", "sk": "slk", "sl": "slv", "sr": "srp",
+	"sv": "swe", "tr": "tur", "uk": "ukr", "zh": "zho",
+}
+
+func LanguageCode(language string) string {
+	if i := strings.Index(language, "-"); i > 0 {
+		language = language[:i]
+	}
+	if code, ok := languageCodes[language]; ok {
+		return code
+	}
+	return "eng"
+}
+
+// getEpisodes fetches every page of the episodes in the seasonType order,
+// translated when language isn't empty.
+func getEpisodes(tvdbId string, seasonType string, language string) ([]*episodeRecord, error) {
+	endpoint := fmt.Sprintf("series/%s/episodes/%s", tvdbId, seasonType)
+	if language != "" {
+		endpoint += "/" + language
+	}
+	episodes := make([]*episodeRecord, 0)
+	for page := 0; ; page++ {
+		var result struct {
+			Episodes []*episodeRecord `json:"episodes"`
+		}
+		next, err := get(endpoint, napping.Params{"page": strconv.Itoa(page)}, &result)
+		if err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, result.Episodes...)
+		if next == false || len(result.Episodes) == 0 {
+			break
+		}
+	}
+	return episodes, nil
+}
+
+// getArtworks returns the artwork of a show, best rated first. seasons maps
+// the season ids to their number.
+func getArtworks(tvdbId string, seasons map[int]int) ([]*Banner, error) {
+	var result struct {
+		Artworks []*artworkRecord `json:"artworks"`
+	}
+	if _, err := get(fmt.Sprintf("series/%s/artworks", tvdbId), nil, &result); err != nil {
+		return nil, err
 	}
-	var banners struct {
-		Banners []*Banner `xml:"Banner"`
+	banners := make([]*Banner, 0, len(result.Artworks))
+	for _, artwork := range result.Artworks {
+		bannerType, ok := artworkTypes[artwork.Type]
+		if ok == false {
+			continue
+		}
+		banner := &Banner{
+			Id:            strconv.Itoa(artwork.Id),
+			BannerPath:    artwork.Image,
+			BannerType:    bannerType[0],
+			BannerType2:   bannerType[1],
+			Language:      artwork.Language,
+			Rating:        strconv.FormatFloat(artwork.Score, 'f', -1, 64),
+			ThumbnailPath: artwork.Thumbnail,
+			Season:        seasons[artwork.SeasonId],
+		}
+		if banner.BannerType2 == "" && artwork.Width > 0 {
+			banner.BannerType2 = fmt.Sprintf("%dx%d", artwork.Width, artwork.Height)
+		}
+		banners = append(banners, banner)
 	}
-	var actors struct {
-		Actors []*Actor `xml:"Actor"`
+	sort.Sort(sort.Reverse(BannersByRating(banners)))
+	return banners, nil
+}
+
+// GetArtworks returns the artwork of a show without fetching its episodes,
+// which is all listings need.
+func GetArtworks(tvdbId string) ([]*Banner, error) {
+	var banners []*Banner
+	key := fmt.Sprintf("com.tvdb.artworks.%s", tvdbId)
+	if err := cacheStore().Get(key, &banners); err == nil {
+		return banners, nil
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/%s/series/%s/all/%s.zip", tvdbEndpoint, apiKey, tvdbId, language))
+	var serie seriesRecord
+	if _, err := get(fmt.Sprintf("series/%s/extended", tvdbId), napping.Params{"short": "true"}, &serie); err != nil {
+		return nil, err
+	}
+	seasons := make(map[int]int)
+	for _, season := range serie.Seasons {
+		seasons[season.Id] = season.Number
+	}
+	banners, err := getArtworks(tvdbId, seasons)
 	if err != nil {
 		return nil, err
 	}
+	cacheStore().Set(key, banners, artworkCacheTime)
+	return banners, nil
+}
+
+func NewShow(tvdbId string, language string) (*Show, error) {
+	language = LanguageCode(language)
+
+	var serie seriesRecord
+	if _, err := get(fmt.Sprintf("series/%s/extended", tvdbId), nil, &serie); err != nil {
+		return nil, err
+	}
 
-	b, err := ioutil.ReadAll(resp.Body)
+	var translation translationRecord
+	if _, err := get(fmt.Sprintf("series/%s/translations/%s", tvdbId, language), nil, &translation); err != nil && IsNotFound(err) == false {
+		return nil, err
+	}
+
+	seasons := make(map[int]int)
+	for _, season := range serie.Seasons {
+		seasons[season.Id] = season.Number
+	}
+	banners, err := getArtworks(tvdbId, seasons)
 	if err != nil {
 		return nil, err
 	}
 
-	br := bytes.NewReader(b)
-	zipReader, err := zip.NewReader(br, int64(br.Len()))
+	episodes, err := getEpisodes(tvdbId, "default", language)
+	if IsNotFound(err) {
+		episodes, err = getEpisodes(tvdbId, "default", "")
+	}
 	if err != nil {
 		return nil, err
 	}
-	for _, file := range zipReader.File {
-		f, err := file.Open()
-		if err != nil {
-			return nil, err
+
+	// Not every show has a DVD order
+	dvdOrder := make(map[int]*episodeRecord)
+	if dvdEpisodes, err := getEpisodes(tvdbId, "dvd", ""); err != nil {
+		tvdbLog.Warningf("Unable to get DVD order of %s: %s", tvdbId, err)
+	} else {
+		for _, episode := range dvdEpisodes {
+			dvdOrder[episode.Id] = episode
 		}
-		defer f.Close()
-		decoder := xml.NewDecoder(f)
-		switch file.Name {
-		case language + ".xml":
-			if err := decoder.Decode(&serie); err != nil {
-				return nil, err
-			}
-		case "banners.xml":
-			if err := decoder.Decode(&banners); err != nil {
-				return nil, err
-			}
-		case "actors.xml":
-			if err := decoder.Decode(&actors); err != nil {
-				return nil, err
-			}
+	}
+
+	show := &Show{
+		Id:            serie.Id,
+		FirstAired:    serie.FirstAired,
+		Language:      language,
+		Overview:      serie.Overview,
+		Rating:        strconv.FormatFloat(serie.Score, 'f', -1, 64),
+		RuntimeString: strconv.Itoa(serie.AverageRuntime),
+		Runtime:       serie.AverageRuntime,
+		SeriesName:    serie.Name,
+		Poster:        serie.Image,
+		Banners:       banners,
+		Actors:        make([]*Actor, 0),
+		Seasons:       make([]*Season, 0),
+	}
+	if translation.Name != "" {
+		show.SeriesName = translation.Name
+	}
+	if translation.Overview != "" {
+		show.Overview = translation.Overview
+	}
+	if serie.Status != nil {
+		show.Status = serie.Status.Name
+	}
+	if serie.LatestNetwork != nil {
+		show.Network = serie.LatestNetwork.Name
+		show.NetworkId = strconv.Itoa(serie.LatestNetwork.Id)
+	}
+	if lastUpdated, err := time.Parse("2006-01-02 15:04:05", serie.LastUpdated); err == nil {
+		show.LastUpdated = int(lastUpdated.Unix())
+	}
+	// ToListItems expects the time as the legacy API had it
+	if airsTime, err := time.Parse("15:04", serie.AirsTime); err == nil {
+		show.AirsTime = airsTime.Format("3:04 PM")
+	} else {
+		show.AirsTime = serie.AirsTime
+	}
+	for _, day := range weekDays {
+		if serie.AirsDays[day] {
+			show.AirsDayOfWeek = strings.Title(day)
+			break
+		}
+	}
+	for _, rating := range serie.ContentRatings {
+		if rating.Country == "usa" {
+			show.ContentRating = rating.Name
+			break
+		}
+	}
+	for _, remoteId := range serie.RemoteIds {
+		if remoteId.SourceName == "IMDB" {
+			show.ImdbId = remoteId.Id
 		}
 	}
+	genres := make([]string, 0, len(serie.Genres))
+	for _, genre := range serie.Genres {
+		genres = append(genres, genre.Name)
+	}
+	if len(genres) > 0 {
+		show.Genre = "|" + strings.Join(genres, "|") + "|"
+	}
 
-	show := serie.Serie
-	show.Actors = actors.Actors
-	show.Banners = banners.Banners
-	show.Seasons = make([]*Season, 0)
+	actorNames := make([]string, 0)
+	for _, character := range serie.Characters {
+		if character.PeopleType != "Actor" {
+			continue
+		}
+		image := character.PersonImgURL
+		if image == "" {
+			image = character.Image
+		}
+		show.Actors = append(show.Actors, &Actor{
+			Id:        strconv.Itoa(character.Id),
+			Image:     image,
+			Name:      character.PersonName,
+			Role:      character.Name,
+			SortOrder: character.Sort,
+		})
+		actorNames = append(actorNames, character.PersonName)
+	}
+	if len(actorNames) > 0 {
+		show.ActorsSimple = "|" + strings.Join(actorNames, "|") + "|"
+	}
 
-	sort.Sort(sort.Reverse(BannersByRating(banners.Banners)))
-	sort.Sort(BySeasonAndEpisodeNumber(serie.Episodes))
+	for _, banner := range banners {
+		switch {
+		case banner.BannerType == "series" && show.Banner == "":
+			show.Banner = banner.BannerPath
+		case banner.BannerType == "fanart" && show.FanArt == "":
+			show.FanArt = banner.BannerPath
+		case banner.BannerType == "poster" && show.Poster == "":
+			show.Poster = banner.BannerPath
+		}
+	}
 
-	if rt, err := strconv.Atoi(show.RuntimeString); err == nil {
-		show.Runtime = rt
+	showEpisodes := make([]*Episode, 0, len(episodes))
+	for _, record := range episodes {
+		episode := &Episode{
+			Id:             strconv.Itoa(record.Id),
+			EpisodeName:    record.Name,
+			EpisodeNumber:  record.Number,
+			FirstAired:     record.Aired,
+			Language:       language,
+			Overview:       record.Overview,
+			SeasonNumber:   record.SeasonNumber,
+			FileName:       record.Image,
+			LastUpdated:    record.LastUpdated,
+			SeriesId:       strconv.Itoa(record.SeriesId),
+			AbsoluteNumber: record.AbsoluteNumber,
+		}
+		if dvd, ok := dvdOrder[record.Id]; ok {
+			episode.DVDSeason = dvd.SeasonNumber
+			episode.DVDEpisode = dvd.Number
+		}
+		showEpisodes = append(showEpisodes, episode)
 	}
+	sort.Sort(BySeasonAndEpisodeNumber(showEpisodes))
 
 	curSeasonNumber := -1
-	for _, episode := range serie.Episodes {
+	for _, episode := range showEpisodes {
 		for _ = 0; curSeasonNumber < episode.SeasonNumber; curSeasonNumber++ {
 			show.Seasons = append(show.Seasons, &Season{
 				Season:   episode.SeasonNumber,
@@ -184,9 +471,6 @@ func NewShow(tvdbId string, language string) (*Show, error) {
 			})
 		}
 		season := show.Seasons[curSeasonNumber]
-		if an, err := strconv.Atoi(episode.AbsoluteNumberString); err == nil {
-			episode.AbsoluteNumber = an
-		}
 		season.Episodes = append(season.Episodes, episode)
 	}
 
@@ -195,21 +479,63 @@ func NewShow(tvdbId string, language string) (*Show, error) {
 
 func NewShowCached(tvdbId string, language string) (*Show, error) {
 	var show *Show
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
 	key := fmt.Sprintf("com.tvdb.show.%s.%s", tvdbId, language)
-	if err := cacheStore.Get(key, &show); err != nil {
+	if err := cacheStore().Get(key, &show); err != nil {
 		newShow, err := NewShow(tvdbId, language)
 		if err != nil {
 			return nil, err
 		}
 		if newShow != nil {
-			cacheStore.Set(key, newShow, cacheTime)
+			cacheStore().Set(key, newShow, cacheTime)
 		}
 		show = newShow
 	}
 	return show, nil
 }
 
+// FindEpisode returns the episode aired on airDate, which matches across
+// orderings, or the one numbered the same as a fallback.
+func (show *Show) FindEpisode(seasonNumber int, episodeNumber int, airDate string) *Episode {
+	var sameNumber *Episode
+	for _, season := range show.Seasons {
+		for _, episode := range season.Episodes {
+			if airDate != "" && episode.FirstAired == airDate {
+				if episode.SeasonNumber == seasonNumber && episode.EpisodeNumber == episodeNumber {
+					return episode
+				}
+				if sameNumber == nil || sameNumber.FirstAired != airDate {
+					sameNumber = episode
+				}
+			} else if episode.SeasonNumber == seasonNumber && episode.EpisodeNumber == episodeNumber && sameNumber == nil {
+				sameNumber = episode
+			}
+		}
+	}
+	return sameNumber
+}
+
+// AbsoluteNumbered returns the share of regular episodes having an absolute
+// number, which TVDB mostly has for anime.
+func (show *Show) AbsoluteNumbered() float64 {
+	total := 0
+	numbered := 0
+	for _, season := range show.Seasons {
+		if season.Season == 0 {
+			continue
+		}
+		for _, episode := range season.Episodes {
+			total++
+			if episode.AbsoluteNumber > 0 {
+				numbered++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(numbered) / float64(total)
+}
+
 type BySeasonAndEpisodeNumber []*Episode
 
 func (a BySeasonAndEpisodeNumber) Len() int      { return len(a) }
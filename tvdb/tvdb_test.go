@@ -0,0 +1,259 @@
+package tvdb
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"testing"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"encoding/json"
+	"net/http/httptest"
+
+	"github.com/scakemyer/quasar/config"
+)
+
+const testAPIKey = "test-key"
+
+// The cache is stored relative to the empty profile path
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "tvdb")
+	if err != nil {
+		panic(err)
+	}
+	fixtures, _ := filepath.Abs("testdata")
+	os.Chdir(dir)
+	os.Symlink(fixtures, "testdata")
+	retryBaseDelay = time.Millisecond
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeTVDB serves the fixtures of testdata, for the token of its last login.
+type fakeTVDB struct {
+	*httptest.Server
+	lock     sync.Mutex
+	logins   int
+	token    string
+	requests map[string]int
+}
+
+var fixtures = map[string]string{
+	"/series/81189/extended":                       "series_extended.json",
+	"/series/81189/artworks":                       "series_artworks.json",
+	"/series/81189/episodes/default/fra?page=0":    "episodes_default_page0.json",
+	"/series/81189/episodes/default/fra?page=1":    "episodes_default_page1.json",
+	"/series/81189/episodes/dvd?page=0":            "episodes_dvd.json",
+}
+
+func newFakeTVDB(t *testing.T) (*fakeTVDB, func()) {
+	fake := &fakeTVDB{requests: make(map[string]int)}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.lock.Lock()
+		defer fake.lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/login" {
+			var payload map[string]string
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload["apikey"] != testAPIKey {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"status": "failure", "message": "InvalidAPIKey"}`))
+				return
+			}
+			fake.logins++
+			fake.token = fmt.Sprintf("token%d", fake.logins)
+			fmt.Fprintf(w, `{"status": "success", "data": {"token": %q}}`, fake.token)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer " + fake.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"status": "failure", "message": "Unauthorized"}`))
+			return
+		}
+
+		key := r.URL.Path
+		if page := r.URL.Query().Get("page"); page != "" {
+			key += "?page=" + page
+		}
+		fake.requests[key]++
+		fixture, ok := fixtures[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status": "failure", "message": "NotFoundException"}`))
+			return
+		}
+		data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("Unable to read fixture: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}))
+
+	config.Get().TVDBApiKey = testAPIKey
+	cacheStore().Flush()
+	previousEndpoint := tvdbEndpoint
+	tvdbEndpoint = fake.Server.URL
+	return fake, func() {
+		tvdbEndpoint = previousEndpoint
+		fake.Server.Close()
+	}
+}
+
+func TestLoginCachesToken(t *testing.T) {
+	fake, done := newFakeTVDB(t)
+	defer done()
+
+	for i := 0; i < 2; i++ {
+		var serie seriesRecord
+		if _, err := get("series/81189/extended", nil, &serie); err != nil {
+			t.Fatalf("Unable to get series: %s", err)
+		}
+		if serie.Name != "Breaking Bad" {
+			t.Errorf("Expected the data to be unwrapped, got %q", serie.Name)
+		}
+	}
+	if fake.logins != 1 {
+		t.Errorf("Expected a single login, got %d", fake.logins)
+	}
+}
+
+func TestTokenRenewedOnUnauthorized(t *testing.T) {
+	fake, done := newFakeTVDB(t)
+	defer done()
+	cacheStore().Set(tokenKey, "expired", tokenCacheTime)
+
+	var serie seriesRecord
+	if _, err := get("series/81189/extended", nil, &serie); err != nil {
+		t.Fatalf("Expected the token to be renewed, got %s", err)
+	}
+	if fake.logins != 1 {
+		t.Errorf("Expected a single login, got %d", fake.logins)
+	}
+	var token string
+	if err := cacheStore().Get(tokenKey, &token); err != nil || token != fake.token {
+		t.Errorf("Expected the renewed token %q to be cached, got %q", fake.token, token)
+	}
+}
+
+func TestLoginWithoutKey(t *testing.T) {
+	_, done := newFakeTVDB(t)
+	defer done()
+
+	config.Get().TVDBApiKey = ""
+	if _, err := get("series/81189/extended", nil, &seriesRecord{}); err != ErrNoAPIKey {
+		t.Errorf("Expected ErrNoAPIKey, got %v", err)
+	}
+	config.Get().TVDBApiKey = "legacy-key"
+	if _, err := get("series/81189/extended", nil, &seriesRecord{}); err != ErrInvalidAPIKey {
+		t.Errorf("Expected ErrInvalidAPIKey, got %v", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	_, done := newFakeTVDB(t)
+	defer done()
+
+	_, err := get("series/81189/translations/fra", nil, &translationRecord{})
+	if IsNotFound(err) == false {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+}
+
+func TestNewShow(t *testing.T) {
+	fake, done := newFakeTVDB(t)
+	defer done()
+
+	show, err := NewShow("81189", "fr")
+	if err != nil {
+		t.Fatalf("Unable to get show: %s", err)
+	}
+
+	// Missing translations keep the original name
+	if show.SeriesName != "Breaking Bad" || show.Language != "fra" {
+		t.Errorf("Unexpected name %q in %q", show.SeriesName, show.Language)
+	}
+	if show.AirsTime != "10:00 PM" || show.AirsDayOfWeek != "Sunday" {
+		t.Errorf("Unexpected airing %s at %s", show.AirsDayOfWeek, show.AirsTime)
+	}
+	if show.Genre != "|Drama|Crime|" || show.ActorsSimple != "|Bryan Cranston|" {
+		t.Errorf("Unexpected genres %q or actors %q", show.Genre, show.ActorsSimple)
+	}
+	if show.ImdbId != "tt0903747" || show.ContentRating != "TV-MA" || show.Network != "AMC" {
+		t.Errorf("Unexpected ids %q, rating %q or network %q", show.ImdbId, show.ContentRating, show.Network)
+	}
+
+	// Both pages of episodes
+	if fake.requests["/series/81189/episodes/default/fra?page=1"] != 1 {
+		t.Errorf("Expected the second page of episodes to be fetched")
+	}
+	if len(show.Seasons) != 3 || len(show.Seasons[1].Episodes) != 2 || len(show.Seasons[2].Episodes) != 1 {
+		t.Fatalf("Expected 2 episodes in season 1 and 1 in season 2, got %d seasons", len(show.Seasons))
+	}
+
+	// DVD order merged by episode id
+	episode := show.FindEpisode(1, 2, "2008-01-27")
+	if episode == nil || episode.DVDSeason != 1 || episode.DVDEpisode != 3 {
+		t.Errorf("Expected S01E02 to be 1x03 on DVD, got %+v", episode)
+	}
+	episode = show.FindEpisode(2, 1, "")
+	if episode == nil || episode.DVDSeason != 3 || episode.DVDEpisode != 1 || episode.AbsoluteNumber != 8 {
+		t.Errorf("Expected S02E01 to be 3x01 on DVD and absolute 8, got %+v", episode)
+	}
+}
+
+func TestArtworkTypes(t *testing.T) {
+	_, done := newFakeTVDB(t)
+	defer done()
+
+	banners, err := GetArtworks("81189")
+	if err != nil {
+		t.Fatalf("Unable to get artworks: %s", err)
+	}
+	if len(banners) != 7 {
+		t.Errorf("Expected the unknown artwork type to be left out, got %d banners", len(banners))
+	}
+	byId := make(map[string]*Banner)
+	for _, banner := range banners {
+		byId[banner.Id] = banner
+	}
+	expected := []struct {
+		id          string
+		bannerType  string
+		bannerType2 string
+		season      int
+	}{
+		{"1", "series", "graphical", 0},
+		{"2", "poster", "680x1000", 0},
+		{"4", "fanart", "1920x1080", 0},
+		{"5", "season", "season", 2},
+		{"6", "season", "seasonwide", 1},
+		{"7", "clearlogo", "800x310", 0},
+	}
+	for _, e := range expected {
+		banner, ok := byId[e.id]
+		if !ok {
+			t.Errorf("Missing artwork %s", e.id)
+			continue
+		}
+		if banner.BannerType != e.bannerType || banner.BannerType2 != e.bannerType2 || banner.Season != e.season {
+			t.Errorf("Expected artwork %s to be %s/%s of season %d, got %s/%s of season %d",
+				e.id, e.bannerType, e.bannerType2, e.season, banner.BannerType, banner.BannerType2, banner.Season)
+		}
+	}
+
+	// Best rated first
+	for _, banner := range banners {
+		if banner.BannerType == "poster" {
+			if banner.Id != "3" {
+				t.Errorf("Expected the best rated poster first, got %s", banner.Id)
+			}
+			break
+		}
+	}
+}
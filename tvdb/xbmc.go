@@ -9,8 +9,12 @@ import (
 	"github.com/scakemyer/quasar/xbmc"
 )
 
+// imageURL makes paths absolute, the API mostly gives full URLs already.
 func imageURL(path string) string {
-	return tvdbUrl + "/banners/" + path
+	if path == "" || strings.HasPrefix(path, "http") {
+		return path
+	}
+	return artworksUrl + "/banners/" + path
 }
 
 func (seasons SeasonList) ToListItems(show *Show) []*xbmc.ListItem {
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"errors"
+	"strconv"
+	"net/url"
+	"net/http"
+	"crypto/tls"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/proxy"
+	"github.com/scakemyer/quasar/config"
+)
+
+const (
+	dialTimeout = 30 * time.Second
+)
+
+var (
+	httpLog = logging.MustGetLogger("http")
+
+	transports     = map[string]*http.Transport{}
+	transportsLock = sync.Mutex{}
+)
+
+// NewHTTPClient returns a client for outbound metadata and provider traffic,
+// going through the HTTP proxy from the settings if enabled. A zero timeout
+// means no timeout.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: getTransport(false),
+		Timeout:   timeout,
+	}
+}
+
+// NewInsecureHTTPClient is like NewHTTPClient but skips TLS verification, for
+// torrent sites with broken certificates.
+func NewInsecureHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: getTransport(true),
+		Timeout:   timeout,
+	}
+}
+
+// HTTPTransport returns the shared proxied transport, e.g. for XML-RPC clients.
+func HTTPTransport() http.RoundTripper {
+	return getTransport(false)
+}
+
+func proxyKey(conf *config.Configuration, insecure bool) string {
+	if conf.HTTPProxyEnabled == false {
+		return fmt.Sprintf("direct|%v", insecure)
+	}
+	return fmt.Sprintf("%d|%s|%d|%s|%s|%v", conf.HTTPProxyType, conf.HTTPProxyHost, conf.HTTPProxyPort, conf.HTTPProxyLogin, conf.HTTPProxyPassword, insecure)
+}
+
+// Transports are kept per proxy settings so connections get reused, and a
+// settings change takes effect on the next client.
+func getTransport(insecure bool) *http.Transport {
+	conf := config.Get()
+	key := proxyKey(conf, insecure)
+
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	if transport, ok := transports[key]; ok {
+		return transport
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial:  (&net.Dialer{Timeout: dialTimeout}).Dial,
+	}
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if conf.HTTPProxyEnabled {
+		address := net.JoinHostPort(conf.HTTPProxyHost, strconv.Itoa(conf.HTTPProxyPort))
+		switch conf.HTTPProxyType {
+		case config.ProxyTypeHTTP:
+			proxyURL := &url.URL{Scheme: "http", Host: address}
+			if conf.HTTPProxyLogin != "" {
+				proxyURL.User = url.UserPassword(conf.HTTPProxyLogin, conf.HTTPProxyPassword)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		case config.ProxyTypeSocks5:
+			var auth *proxy.Auth
+			if conf.HTTPProxyLogin != "" {
+				auth = &proxy.Auth{User: conf.HTTPProxyLogin, Password: conf.HTTPProxyPassword}
+			}
+			transport.Proxy = nil
+			dialer, err := proxy.SOCKS5("tcp", address, auth, &net.Dialer{Timeout: dialTimeout})
+			if err != nil {
+				// Never fall back to a direct connection, it would leak the
+				// real address
+				httpLog.Errorf("Unable to use SOCKS5 proxy %s, blocking outbound connections: %s", address, err)
+				transport.Dial = func(network, addr string) (net.Conn, error) {
+					return nil, fmt.Errorf("SOCKS5 proxy %s is unusable: %s", address, err)
+				}
+			} else {
+				transport.Dial = dialer.Dial
+			}
+		case config.ProxyTypeSocks4:
+			dialer := &socks4Dialer{address: address, userID: conf.HTTPProxyLogin}
+			transport.Proxy = nil
+			transport.Dial = dialer.Dial
+		}
+	}
+
+	transports[key] = transport
+	return transport
+}
+
+// socks4Dialer speaks SOCKS4a, letting the proxy resolve host names.
+type socks4Dialer struct {
+	address string
+	userID  string
+}
+
+func (d *socks4Dialer) Dial(network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", d.address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// Don't hang on a proxy that accepts but never answers
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	request := []byte{4, 1, byte(port >> 8), byte(port)}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		// 0.0.0.x tells the proxy a host name follows the user id
+		request = append(request, 0, 0, 0, 1)
+	} else {
+		request = append(request, ip...)
+	}
+	request = append(request, []byte(d.userID)...)
+	request = append(request, 0)
+	if ip == nil {
+		request = append(request, []byte(host)...)
+		request = append(request, 0)
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response := make([]byte, 8)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if response[1] != 0x5a {
+		conn.Close()
+		return nil, errors.New("SOCKS4 proxy refused the connection")
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -2,6 +2,7 @@ package xbmc
 
 import (
 	"net"
+	"errors"
 
 	"github.com/scakemyer/quasar/jsonrpc"
 )
@@ -62,3 +63,22 @@ func executeJSONRPCEx(method string, retVal interface{}, args []interface{}) err
 	client := jsonrpc.NewClient(conn)
 	return client.Call(method, args, retVal)
 }
+
+// callJSONRPC calls Kodi's JSON-RPC with named parameters, returning an
+// error instead of panicking when Kodi can't be reached.
+func callJSONRPC(method string, retVal interface{}, args Object) error {
+	if args == nil {
+		args = Object{}
+	}
+	conn, err := getConnection(XBMCJSONRPCHosts...)
+	if conn == nil {
+		if err == nil {
+			err = errors.New("Unable to connect to Kodi's JSON-RPC")
+		}
+		return err
+	}
+	defer conn.Close()
+
+	client := jsonrpc.NewClient(conn)
+	return client.Call(method, args, retVal)
+}
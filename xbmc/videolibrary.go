@@ -0,0 +1,58 @@
+package xbmc
+
+type VideoResume struct {
+	Position float64 `json:"position"`
+	Total    float64 `json:"total"`
+}
+
+type VideoLibraryMovie struct {
+	ID        int          `json:"movieid"`
+	File      string       `json:"file"`
+	PlayCount int          `json:"playcount"`
+	Resume    *VideoResume `json:"resume"`
+}
+
+type VideoLibraryEpisode struct {
+	ID        int          `json:"episodeid"`
+	File      string       `json:"file"`
+	PlayCount int          `json:"playcount"`
+	Resume    *VideoResume `json:"resume"`
+}
+
+var videoLibraryProperties = []string{"file", "playcount", "resume"}
+
+func VideoLibraryGetMovies() ([]*VideoLibraryMovie, error) {
+	var retVal struct {
+		Movies []*VideoLibraryMovie `json:"movies"`
+	}
+	err := callJSONRPC("VideoLibrary.GetMovies", &retVal, Object{
+		"properties": videoLibraryProperties,
+	})
+	return retVal.Movies, err
+}
+
+func VideoLibraryGetEpisodes() ([]*VideoLibraryEpisode, error) {
+	var retVal struct {
+		Episodes []*VideoLibraryEpisode `json:"episodes"`
+	}
+	err := callJSONRPC("VideoLibrary.GetEpisodes", &retVal, Object{
+		"properties": videoLibraryProperties,
+	})
+	return retVal.Episodes, err
+}
+
+func VideoLibrarySetMoviePlayCount(movieId int, playCount int) error {
+	var retVal string
+	return callJSONRPC("VideoLibrary.SetMovieDetails", &retVal, Object{
+		"movieid":   movieId,
+		"playcount": playCount,
+	})
+}
+
+func VideoLibrarySetEpisodePlayCount(episodeId int, playCount int) error {
+	var retVal string
+	return callJSONRPC("VideoLibrary.SetEpisodeDetails", &retVal, Object{
+		"episodeid": episodeId,
+		"playcount": playCount,
+	})
+}
@@ -80,3 +80,17 @@ func GetLanguageISO_639_1() string{
 	}
 	return language
 }
+
+// GetRegionalLanguage is like GetLanguageISO_639_1 but keeps the region of
+// the languages TMDB translates separately, e.g. pt-BR.
+func GetRegionalLanguage() string {
+	switch GetLanguage(EnglishName) {
+	case "Chinese (Simple)":      return "zh-CN"
+	case "Chinese (Traditional)": return "zh-TW"
+	case "French (Canada)":       return "fr-CA"
+	case "Portuguese (Brazil)":   return "pt-BR"
+	case "Spanish (Argentina)":   return "es-AR"
+	case "Spanish (Mexico)":      return "es-MX"
+	}
+	return GetLanguageISO_639_1()
+}
@@ -159,6 +159,17 @@ func PlayerIsPaused() bool {
 	return retVal != 0
 }
 
+// PlayerGetPercentage returns how far the video player is in the current
+// file, from 0 to 100.
+func PlayerGetPercentage() float64 {
+	var retVal struct {
+		Percentage float64 `json:"percentage"`
+	}
+	// Kodi's video player always has the id 1
+	executeJSONRPC("Player.GetProperties", &retVal, Args{1, []string{"percentage"}})
+	return retVal.Percentage
+}
+
 func CloseAllDialogs() bool {
 	retVal := 0
 	executeJSONRPCEx("Dialog_CloseAll", &retVal, nil)
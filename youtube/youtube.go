@@ -4,10 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+
+	"github.com/scakemyer/quasar/util"
 )
 
 const (
@@ -39,7 +40,7 @@ type YTSearchItems struct {
 func Search(name string) (string, error){
     url := fmt.Sprintf(searchLink, url.QueryEscape(name + " trailer"), youtubeKey)
 
- 	r, err := http.Get(url)
+ 	r, err := util.NewHTTPClient(0).Get(url)
 	if err != nil {
 		return "", err
 	}
@@ -60,7 +61,7 @@ func Search(name string) (string, error){
 }
 
 func Resolve(youtubeId string) ([]string, error) {
-	resp, err := http.Get(fmt.Sprintf(watchLink, youtubeId))
+	resp, err := util.NewHTTPClient(0).Get(fmt.Sprintf(watchLink, youtubeId))
 	if err != nil {
 		return nil, err
 	}